@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/happycakefriends/certainly/pkg/availability"
+	"github.com/happycakefriends/certainly/pkg/resolver"
+)
+
+// availabilityCommand checks the registration status of the candidate domains
+func availabilityCommand(args []string) int {
+	fs := flag.NewFlagSet("availability", flag.ExitOnError)
+	filePtr := fs.String("f", "", "file to read the candidate domains from, one per line. Use - for stdin")
+	resolverPtr := fs.String("resolver", "8.8.8.8:53", "recursive DNS resolver to use, see the resolver section of config.cfg for the supported formats")
+	formatPtr := fs.String("format", "csv", "output format: csv or json")
+	workersPtr := fs.Int("workers", 10, "number of concurrent lookups")
+	parkingPtr := fs.String("parking", strings.Join(availability.DefaultParkingNS, ","), "comma separated list of nameserver domains of parking services")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: certainly availability [options] [candidate.tld ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) //nolint:all
+
+	domains := fs.Args()
+	if *filePtr != "" {
+		fileDomains, err := readDomainList(*filePtr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 1
+		}
+		domains = append(domains, fileDomains...)
+	}
+	if len(domains) == 0 {
+		fs.Usage()
+		return 1
+	}
+	if err := resolver.ValidUpstream(*resolverPtr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	checker := availability.Checker{
+		Resolver:  resolver.New([]string{*resolverPtr}, 5*time.Second, 1, false),
+		ParkingNS: strings.Split(*parkingPtr, ","),
+		Workers:   *workersPtr,
+	}
+	results := checker.CheckAll(domains)
+
+	switch *formatPtr {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"domain", "status", "nameservers", "error"}) //nolint:all
+		for _, r := range results {
+			w.Write([]string{r.Domain, r.Status, strings.Join(r.Nameservers, " "), r.Error}) //nolint:all
+		}
+		w.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results) //nolint:all
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown output format %s\n", *formatPtr)
+		return 1
+	}
+	return 0
+}
+
+// readDomainList reads a list of domains, ignoring empty lines and comments
+func readDomainList(fname string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	domains := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, strings.Fields(line)[0])
+	}
+	return domains, scanner.Err()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// checkConfigCommand validates the configuration file and reports every problem with its line number
+func checkConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPtr := fs.String("c", "./config.cfg", "config file location")
+	fs.Parse(args) //nolint:all
+
+	_, usedConfigFile, err := certainly.ReadConfig(*configPtr)
+	var configErrs certainly.ConfigErrors
+	var parseErr toml.ParseError
+	switch {
+	case err == nil:
+		fmt.Printf("%s: configuration is valid\n", usedConfigFile)
+		return 0
+	case errors.As(err, &configErrs):
+		for _, e := range configErrs {
+			fmt.Println(e.Error())
+		}
+		fmt.Printf("%s: %d problem(s) found\n", usedConfigFile, len(configErrs))
+	case errors.As(err, &parseErr):
+		fmt.Printf("%s:%d: invalid TOML\n\n", usedConfigFile, parseErr.Position.Line)
+		fmt.Print(parseErr.ErrorWithUsage())
+	default:
+		fmt.Printf("Error: %s\n", err)
+	}
+	return 1
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/dnssec"
+)
+
+// dnssecDSCommand prints the DS records of the managed zones for the registrar, generating the keys if needed
+func dnssecDSCommand(args []string) int {
+	fs := flag.NewFlagSet("dnssec-ds", flag.ExitOnError)
+	configPtr := fs.String("c", "./config.cfg", "config file location")
+	fs.Parse(args) //nolint:all
+
+	config, _, err := certainly.ReadConfig(*configPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	zoneFiles := []string{}
+	for zone := range config.NS.ZoneFiles {
+		zoneFiles = append(zoneFiles, zone)
+	}
+	sort.Strings(zoneFiles)
+	zones := append(append([]string{config.NS.DefaultDomain}, config.NS.Domains...), zoneFiles...)
+	seen := map[string]bool{}
+	for _, zone := range zones {
+		zone = strings.ToLower(dns.Fqdn(zone))
+		if zone == "." || seen[zone] {
+			continue
+		}
+		seen[zone] = true
+		key, err := dnssec.LoadOrCreate(config.NS.DNSSECKeyDir, zone)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not load DNSSEC key for %s: %s\n", zone, err)
+			return 1
+		}
+		fmt.Println(key.DS().String())
+		fmt.Println(key.DNSKEY.String())
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
+)
+
+// eventsCommand dumps the events from the event store as JSON lines
+func eventsCommand(args []string) int {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	configPtr := fs.String("c", "./config.cfg", "config file location")
+	dbPtr := fs.String("db", "", "event database location, overrides the path from config")
+	fromPtr := fs.String("from", "", "only show events after this time (RFC3339 or YYYY-MM-DD)")
+	toPtr := fs.String("to", "", "only show events up to this time (RFC3339, or YYYY-MM-DD for the end of the day)")
+	protoPtr := fs.String("protocol", "", "only show events for this protocol: dns, http, smtp or imap")
+	fs.Parse(args) //nolint:all
+
+	dbPath := *dbPtr
+	if dbPath == "" {
+		config, _, err := certainly.ReadConfig(*configPtr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 1
+		}
+		dbPath = config.Events.Path
+	}
+	from, err := parseTimeFlag(*fromPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -from value: %s\n", err)
+		return 1
+	}
+	to, err := parseEndTimeFlag(*toPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -to value: %s\n", err)
+		return 1
+	}
+	store, err := events.OpenBoltStore(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	err = store.Query(from, to, func(event certainly.Event) error {
+		if *protoPtr != "" && event.Protocol != *protoPtr {
+			return nil
+		}
+		return enc.Encode(event)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseEndTimeFlag parses the end of a time range. A date without a time covers the whole day, so it ends right
+// before the midnight of the next day.
+func parseEndTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return parseTimeFlag(value)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/happycakefriends/certainly/pkg/bitflip"
+)
+
+// generateCommand prints the bitflip and typosquat candidates for the target domains
+func generateCommand(args []string) int {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	typesPtr := fs.String("types", strings.Join(bitflip.Categories, ","), "comma separated list of candidate types to generate")
+	formatPtr := fs.String("format", "config", "output format: config or list")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: certainly generate [options] target.tld [another.tld ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) //nolint:all
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	categories := strings.Split(*typesPtr, ",")
+	for _, c := range categories {
+		if !isCategory(c) {
+			fmt.Fprintf(os.Stderr, "Error: unknown candidate type %s, valid types are: %s\n", c, strings.Join(bitflip.Categories, ", "))
+			return 1
+		}
+	}
+	targets := []string{}
+	for _, t := range fs.Args() {
+		t = strings.ToLower(strings.TrimSuffix(t, "."))
+		if !bitflip.ValidDomain(t) {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a valid domain name\n", t)
+			return 1
+		}
+		targets = append(targets, t)
+	}
+	candidates := bitflip.GenerateAll(targets, categories)
+
+	switch *formatPtr {
+	case "config":
+		writeConfigCandidates(os.Stdout, targets, candidates)
+	case "list":
+		for _, c := range candidates {
+			fmt.Println(c.Domain)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown output format %s\n", *formatPtr)
+		return 1
+	}
+	return 0
+}
+
+// writeConfigCandidates writes the candidates as config.cfg domains and rewrites entries
+func writeConfigCandidates(w io.Writer, targets []string, candidates []bitflip.Candidate) {
+	fmt.Fprintf(w, "# Generated for: %s\n\n", strings.Join(targets, ", "))
+	fmt.Fprintf(w, "# [ns] section\ndomains = [\n")
+	for _, c := range candidates {
+		fmt.Fprintf(w, "    %-40s # %s\n", fmt.Sprintf("%q,", c.Domain), describeCandidate(c))
+	}
+	fmt.Fprintf(w, "]\n\n[rewrites]\n")
+	for _, c := range candidates {
+		fmt.Fprintf(w, "  %q = %q\n", c.Domain, c.Target)
+	}
+}
+
+func describeCandidate(c bitflip.Candidate) string {
+	if c.Category == bitflip.CategoryBitflip {
+		return fmt.Sprintf("%s, %s position %d bit %d", c.Target, c.Category, c.Position, c.Bit)
+	}
+	return fmt.Sprintf("%s, %s position %d", c.Target, c.Category, c.Position)
+}
+
+func isCategory(category string) bool {
+	for _, c := range bitflip.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@ require (
 	github.com/caddyserver/certmagic v0.21.3
 	github.com/emersion/go-message v0.18.0
 	github.com/mholt/acmez/v2 v2.0.1
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
@@ -1,21 +1,49 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/happycakefriends/certainly/pkg/api"
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
 	"github.com/happycakefriends/certainly/pkg/httpd"
 	"github.com/happycakefriends/certainly/pkg/imapd"
+	"github.com/happycakefriends/certainly/pkg/lifecycle"
 	"github.com/happycakefriends/certainly/pkg/nameserver"
 	"github.com/happycakefriends/certainly/pkg/notification"
+	"github.com/happycakefriends/certainly/pkg/ratelimit"
+	"github.com/happycakefriends/certainly/pkg/resolver"
 	"github.com/happycakefriends/certainly/pkg/smtpd"
+	"github.com/happycakefriends/certainly/pkg/util"
 
 	"go.uber.org/zap"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "events":
+			os.Exit(eventsCommand(os.Args[2:]))
+		case "report":
+			os.Exit(reportCommand(os.Args[2:]))
+		case "generate":
+			os.Exit(generateCommand(os.Args[2:]))
+		case "availability":
+			os.Exit(availabilityCommand(os.Args[2:]))
+		case "dnssec-ds":
+			os.Exit(dnssecDSCommand(os.Args[2:]))
+		case "check-config":
+			os.Exit(checkConfigCommand(os.Args[2:]))
+		case "print-config":
+			os.Exit(printConfigCommand(os.Args[2:]))
+		}
+	}
 
 	configPtr := flag.String("c", "./config.cfg", "config file location")
 	flag.Parse()
@@ -38,36 +66,85 @@ func main() {
 
 	sugar.Infow("Using config file",
 		"file", usedConfigFile)
+	util.SetResolver(resolver.New(config.Resolver.Upstreams, time.Duration(config.Resolver.Timeout)*time.Second, config.Resolver.Retries, config.Resolver.Cache))
 	sugar.Info("Starting up")
-	// Error channel for servers
-	errChan := make(chan error, 1)
 
 	notifications := notification.Initialize(&config, sugar)
+	eventlog := events.Initialize(&config, sugar)
+	manager := lifecycle.New(sugar)
 
-	dnsservers := nameserver.InitAndStart(&config, sugar, notifications, errChan)
+	limiter := ratelimit.New(&config, sugar, notifications)
+	dnsserver := nameserver.Initialize(&config, sugar, notifications, eventlog, limiter)
+	reloader := newReloader(usedConfigFile, &config, sugar)
+	reloader.Add(dnsserver, notifications, limiter, eventlog)
+	adminAPI := api.Initialize(&config, sugar, dnsserver)
+	adminAPI.Health = manager.Health
+	adminAPI.RateLimit = limiter.Stats
+	// The nameserver needs to be up to answer the ACME challenges for the certificates
+	manager.Start(dnsserver.Listeners()...)
+	manager.Start(adminAPI.Listeners()...)
 
-	tlsconfig, err := setupTLS(dnsservers, &config, sugar)
+	tlsfilters, err := newTLSFilters(&config)
 	if err != nil {
-		sugar.Fatalf("Could not start, error in creating TLS config",
+		sugar.Fatalw("Could not compile the TLS filters",
 			"error", err)
 	}
-	imaptlsconfig, err := setupTLS(dnsservers, &config, sugar)
+	reloader.Add(tlsfilters)
+	tlsconfig, err := setupTLS(dnsserver, reloader.Config, tlsfilters, sugar)
 	if err != nil {
 		sugar.Fatalf("Could not start, error in creating TLS config",
 			"error", err)
 	}
-	smtpd := smtpd.Initialize(&config, tlsconfig, sugar, notifications, errChan)
-	imapd := imapd.Initialize(&config, imaptlsconfig, sugar, notifications, errChan)
-	httpd.InitAndStart(&config, tlsconfig, sugar, notifications, errChan)
-	smtpd.Start()
-	imapd.Start()
+	imaptlsconfig, err := setupTLS(dnsserver, reloader.Config, tlsfilters, sugar)
 	if err != nil {
-		sugar.Error(err)
+		sugar.Fatalf("Could not start, error in creating TLS config",
+			"error", err)
 	}
-	for {
-		err = <-errChan
-		if err != nil {
-			sugar.Fatal(err)
+	smtpd := smtpd.Initialize(&config, tlsconfig, sugar, notifications, eventlog)
+	imapd := imapd.Initialize(&config, imaptlsconfig, sugar, notifications, eventlog)
+	httpd := httpd.Initialize(&config, tlsconfig, sugar, notifications, eventlog)
+	manager.Start(httpd.Listeners()...)
+	manager.Start(smtpd.Listeners()...)
+	manager.Start(imapd.Listeners()...)
+	reloader.Add(httpd)
+	go reloader.watch()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		sugar.Info("Received SIGHUP, reloading the configuration")
+		reloader.reloadAndLog()
+		sig = <-signals
+	}
+	shutdownTimeout := reloader.Config().General.ShutdownTimeout
+	sugar.Infow("Shutting down",
+		"signal", sig.String(),
+		"timeout", shutdownTimeout)
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				break
+			}
 		}
+		sugar.Warn("Received a second signal, exiting without waiting")
+		logger.Sync() //nolint:all
+		os.Exit(1)
+	}()
+	timeout := time.Duration(shutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := manager.Shutdown(ctx); err != nil {
+		sugar.Errorw("Listeners did not shut down cleanly",
+			"error", err)
+	}
+	// The captures are recorded by now, send out what's left in the notification queue and close the event store
+	notifyCtx, notifyCancel := context.WithTimeout(context.Background(), timeout)
+	defer notifyCancel()
+	if err := notifications.Close(notifyCtx); err != nil {
+		sugar.Errorw("Could not send all the queued notifications",
+			"error", err)
 	}
+	eventlog.Close()
+	sugar.Info("Shut down")
 }
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/lifecycle"
+	"github.com/happycakefriends/certainly/pkg/nameserver"
+	"github.com/happycakefriends/certainly/pkg/ratelimit"
+)
+
+// API is the authenticated HTTP/JSON admin API for managing the DNS records and domains at runtime
+type API struct {
+	Config *certainly.CertainlyCFG
+	Logger *zap.SugaredLogger
+	Server certainly.CertainlyNS
+	// Health reports the state of the listeners of all the servers
+	Health func() []lifecycle.Health
+	// RateLimit reports the counters of the DNS rate limiter
+	RateLimit func() ratelimit.Stats
+	state     *State
+	// mu serializes the changes so that the nameserver and the state file stay in sync
+	mu sync.Mutex
+}
+
+type domainRequest struct {
+	Domain string `json:"domain"`
+}
+
+type recordRequest struct {
+	Record string `json:"record"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Initialize creates the admin API and applies the persisted changes to the nameserver if the API is enabled
+func Initialize(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, server certainly.CertainlyNS) *API {
+	a := &API{
+		Config: config,
+		Logger: logger,
+		Server: server,
+		state:  &State{},
+	}
+	if !config.API.Enabled {
+		return a
+	}
+	if config.API.StateFile != "" {
+		state, err := LoadState(config.API.StateFile)
+		if err != nil {
+			logger.Errorw("Could not load the admin API state file",
+				"file", config.API.StateFile,
+				"error", err)
+		} else {
+			a.state = state
+			a.applyState()
+		}
+	}
+	return a
+}
+
+// Listeners returns the API listener, or none if the API is disabled
+func (a *API) Listeners() []certainly.Listener {
+	if !a.Config.API.Enabled {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/domains", a.authenticated(a.handleDomains))
+	mux.HandleFunc("/domains/", a.authenticated(a.handleDomain))
+	mux.HandleFunc("/records", a.authenticated(a.handleRecords))
+	mux.HandleFunc("/health", a.authenticated(a.handleHealth))
+	mux.HandleFunc("/ratelimit", a.authenticated(a.handleRateLimit))
+	stderrorlog, err := zap.NewStdLogAt(a.Logger.Desugar(), zap.ErrorLevel)
+	if err != nil {
+		a.Logger.Errorw("Could not create the admin API error logger",
+			"error", err)
+	}
+	srv := &http.Server{
+		Addr:              a.Config.API.Listen,
+		Handler:           mux,
+		ErrorLog:          stderrorlog,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return []certainly.Listener{lifecycle.NewHTTPListener("api "+srv.Addr, srv)}
+}
+
+// applyState replays the persisted changes on top of the records from the configuration
+func (a *API) applyState() {
+	for _, d := range a.state.DomainsAdded {
+		a.logApplyError("add domain", d, a.Server.AddDomain(d))
+	}
+	for _, d := range a.state.DomainsDeleted {
+		a.logApplyError("delete domain", d, a.Server.DeleteDomain(d))
+	}
+	for _, r := range a.state.RecordsAdded {
+		a.logApplyError("add record", r, a.Server.AddRecord(r))
+	}
+	for _, r := range a.state.RecordsDeleted {
+		a.logApplyError("delete record", r, a.Server.DeleteRecord(r))
+	}
+}
+
+func (a *API) logApplyError(action, value string, err error) {
+	if err != nil {
+		a.Logger.Errorw("Could not apply the persisted change",
+			"action", action,
+			"value", value,
+			"error", err)
+	}
+}
+
+// change applies the change to the nameserver and persists it to the state file
+func (a *API) change(w http.ResponseWriter, r *http.Request, action, value string, change func() error, persist func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := change(); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, nameserver.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	a.Logger.Infow("Changed DNS configuration through the admin API",
+		"action", action,
+		"value", value,
+		"remoteAddr", r.RemoteAddr)
+	if a.Config.API.StateFile != "" {
+		persist()
+		if err := a.state.Save(a.Config.API.StateFile); err != nil {
+			a.Logger.Errorw("Could not write the admin API state file",
+				"file", a.Config.API.StateFile,
+				"error", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "change applied but could not be persisted"})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handleDomains(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.Server.ListDomains())
+	case http.MethodPost:
+		req := domainRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Domain == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expected a JSON object with the domain"})
+			return
+		}
+		a.change(w, r, "add domain", req.Domain,
+			func() error { return a.Server.AddDomain(req.Domain) },
+			func() { a.state.AddDomain(req.Domain) })
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	}
+}
+
+func (a *API) handleDomain(w http.ResponseWriter, r *http.Request) {
+	domain := strings.TrimPrefix(r.URL.Path, "/domains/")
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.Server.ListRecords(domain))
+	case http.MethodDelete:
+		a.change(w, r, "delete domain", domain,
+			func() error { return a.Server.DeleteDomain(domain) },
+			func() { a.state.DeleteDomain(domain) })
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	}
+}
+
+func (a *API) handleRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, a.Server.ListRecords(r.URL.Query().Get("name")))
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	req := recordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Record == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expected a JSON object with the record in zone file format"})
+		return
+	}
+	if r.Method == http.MethodPost {
+		a.change(w, r, "add record", req.Record,
+			func() error { return a.Server.AddRecord(req.Record) },
+			func() { a.state.AddRecord(req.Record) })
+		return
+	}
+	a.change(w, r, "delete record", req.Record,
+		func() error { return a.Server.DeleteRecord(req.Record) },
+		func() { a.state.DeleteRecord(req.Record) })
+}
+
+func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	health := []lifecycle.Health{}
+	if a.Health != nil {
+		health = a.Health()
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+func (a *API) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	stats := ratelimit.Stats{}
+	if a.RateLimit != nil {
+		stats = a.RateLimit()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// authenticated checks the source address against allow_from and the bearer token against token_hash
+func (a *API) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowedFrom(r.RemoteAddr) {
+			a.Logger.Infow("Admin API request from a disallowed address",
+				"remoteAddr", r.RemoteAddr)
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "forbidden"})
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !certainly.CorrectPassword(token, a.Config.API.TokenHash) {
+			a.Logger.Infow("Admin API request with invalid credentials",
+				"remoteAddr", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *API) allowedFrom(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range a.Config.API.AllowFrom.ValidEntries() {
+		_, cidr, err := net.ParseCIDR(v)
+		if err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// State holds the changes made through the admin API on top of the configuration, so that they can be replayed on startup
+type State struct {
+	DomainsAdded   []string `json:"domains_added"`
+	DomainsDeleted []string `json:"domains_deleted"`
+	RecordsAdded   []string `json:"records_added"`
+	RecordsDeleted []string `json:"records_deleted"`
+}
+
+// LoadState reads the state file, a missing file is an empty state
+func LoadState(filename string) (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save writes the state to a temporary file first and renames it over the old state file
+func (s *State) Save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// AddDomain records an added domain, cancelling out an earlier deletion
+func (s *State) AddDomain(domain string) {
+	domain = normalizeDomain(domain)
+	s.DomainsAdded, s.DomainsDeleted = toggle(s.DomainsAdded, s.DomainsDeleted, domain)
+}
+
+// DeleteDomain records a deleted domain, cancelling out an earlier addition
+func (s *State) DeleteDomain(domain string) {
+	domain = normalizeDomain(domain)
+	s.DomainsDeleted, s.DomainsAdded = toggle(s.DomainsDeleted, s.DomainsAdded, domain)
+}
+
+// AddRecord records an added record, cancelling out an earlier deletion
+func (s *State) AddRecord(record string) {
+	record = normalizeRecord(record)
+	s.RecordsAdded, s.RecordsDeleted = toggle(s.RecordsAdded, s.RecordsDeleted, record)
+}
+
+// DeleteRecord records a deleted record, cancelling out an earlier addition
+func (s *State) DeleteRecord(record string) {
+	record = normalizeRecord(record)
+	s.RecordsDeleted, s.RecordsAdded = toggle(s.RecordsDeleted, s.RecordsAdded, record)
+}
+
+// toggle removes the value from the opposite list if it's there, otherwise adds it to the list
+func toggle(list, opposite []string, value string) ([]string, []string) {
+	for i, v := range opposite {
+		if v == value {
+			return list, append(opposite[:i:i], opposite[i+1:]...)
+		}
+	}
+	for _, v := range list {
+		if v == value {
+			return list, opposite
+		}
+	}
+	return append(list, value), opposite
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(dns.Fqdn(domain))
+}
+
+// normalizeRecord formats the record the same way regardless of how it was written in the request
+func normalizeRecord(record string) string {
+	rr, err := dns.NewRR(record)
+	if err != nil || rr == nil {
+		return record
+	}
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+	return rr.String()
+}
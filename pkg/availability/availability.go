@@ -0,0 +1,134 @@
+package availability
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/resolver"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+const (
+	StatusRegistered = "registered"
+	StatusNXDomain   = "nxdomain"
+	StatusParked     = "parked"
+	StatusError      = "error"
+)
+
+// DefaultParkingNS is a list of nameserver domains used by the common domain parking services
+var DefaultParkingNS = []string{
+	"above.com",
+	"bodis.com",
+	"cashparking.com",
+	"dan.com",
+	"domainparking.ru",
+	"dsredirection.com",
+	"fabulous.com",
+	"hugedomains.com",
+	"namebrightdns.com",
+	"parkingcrew.net",
+	"parklogic.com",
+	"rookdns.com",
+	"sedoparking.com",
+	"smartname.com",
+	"uniregistrymarket.link",
+	"voodoo.com",
+}
+
+// Result is the registration status of a single domain
+type Result struct {
+	Domain      string   `json:"domain"`
+	Status      string   `json:"status"`
+	Nameservers []string `json:"nameservers"`
+	Error       string   `json:"error,omitempty"`
+}
+
+type Checker struct {
+	Resolver  *resolver.Resolver
+	ParkingNS []string
+	Workers   int
+}
+
+// Check queries the NS and SOA records of the domain to find out if it has been registered
+func (c *Checker) Check(domain string) Result {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	result := Result{Domain: domain, Nameservers: []string{}}
+	in, err := c.Resolver.Query(domain, dns.TypeNS)
+	if err != nil {
+		result.Status = StatusError
+		result.Error = err.Error()
+		return result
+	}
+	switch in.Rcode {
+	case dns.RcodeNameError:
+		result.Status = StatusNXDomain
+		return result
+	case dns.RcodeSuccess:
+	default:
+		result.Status = StatusError
+		result.Error = "NS query returned " + dns.RcodeToString[in.Rcode]
+		return result
+	}
+	for _, ans := range in.Answer {
+		if ns, ok := ans.(*dns.NS); ok {
+			result.Nameservers = append(result.Nameservers, strings.ToLower(strings.TrimSuffix(ns.Ns, ".")))
+		}
+	}
+	if len(result.Nameservers) == 0 {
+		// No delegation in the answer, make sure the domain has a zone of its own
+		in, err = c.Resolver.Query(domain, dns.TypeSOA)
+		if err != nil {
+			result.Status = StatusError
+			result.Error = err.Error()
+			return result
+		}
+		if in.Rcode == dns.RcodeNameError {
+			result.Status = StatusNXDomain
+			return result
+		}
+	}
+	result.Status = StatusRegistered
+	if c.isParked(result.Nameservers) {
+		result.Status = StatusParked
+	}
+	return result
+}
+
+// CheckAll checks the domains concurrently, the results are returned in the same order as the domains
+func (c *Checker) CheckAll(domains []string) []Result {
+	results := make([]Result, len(domains))
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.Check(domains[i])
+			}
+		}()
+	}
+	for i := range domains {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (c *Checker) isParked(nameservers []string) bool {
+	for _, ns := range nameservers {
+		for _, parking := range c.ParkingNS {
+			if util.InDomain(ns, parking) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package availability
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/resolver"
+)
+
+// stubRegistry answers the NS and SOA questions like the upstream resolver would for the test domains
+func stubRegistry(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	add := func(s string) {
+		rr, _ := dns.NewRR(s)
+		m.Answer = append(m.Answer, rr)
+	}
+	q := r.Question[0]
+	switch q.Name {
+	case "registered.example.":
+		if q.Qtype == dns.TypeNS {
+			add("registered.example. 60 IN NS ns1.example.net.")
+			add("registered.example. 60 IN NS NS2.Example.Net.")
+		}
+	case "parked.example.":
+		if q.Qtype == dns.TypeNS {
+			add("parked.example. 60 IN NS ns1.sedoparking.com.")
+		}
+	case "lookalike.example.":
+		if q.Qtype == dns.TypeNS {
+			add("lookalike.example. 60 IN NS ns1.jordan.com.")
+			add("lookalike.example. 60 IN NS ns2.sudan.com.")
+		}
+	case "nodelegation.example.":
+		if q.Qtype == dns.TypeSOA {
+			add("nodelegation.example. 60 IN SOA ns1.example.net. admin.example.net. 1 2 3 4 60")
+		}
+	case "broken.example.":
+		m.Rcode = dns.RcodeServerFailure
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+	_ = w.WriteMsg(m)
+}
+
+func newTestChecker(t *testing.T) *Checker {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(stubRegistry), NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe() //nolint:all
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return &Checker{
+		Resolver:  resolver.New([]string{pc.LocalAddr().String()}, time.Second, 0, false),
+		ParkingNS: DefaultParkingNS,
+		Workers:   3,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c := newTestChecker(t)
+	tests := []struct {
+		domain      string
+		status      string
+		nameservers []string
+	}{
+		{"Registered.Example.", StatusRegistered, []string{"ns1.example.net", "ns2.example.net"}},
+		{"parked.example", StatusParked, []string{"ns1.sedoparking.com"}},
+		// The parking domains only match whole labels, dan.com isn't jordan.com
+		{"lookalike.example", StatusRegistered, []string{"ns1.jordan.com", "ns2.sudan.com"}},
+		// The SOA shows that the domain has a zone, even without an NS record in the answer
+		{"nodelegation.example", StatusRegistered, []string{}},
+		{"free.example", StatusNXDomain, []string{}},
+		{"broken.example", StatusError, []string{}},
+	}
+	for _, test := range tests {
+		result := c.Check(test.domain)
+		if result.Status != test.status {
+			t.Errorf("Check(%s) status = %s, want %s (error %q)", test.domain, result.Status, test.status, result.Error)
+		}
+		if !reflect.DeepEqual(result.Nameservers, test.nameservers) {
+			t.Errorf("Check(%s) nameservers = %v, want %v", test.domain, result.Nameservers, test.nameservers)
+		}
+	}
+}
+
+func TestCheckAllKeepsOrder(t *testing.T) {
+	c := newTestChecker(t)
+	domains := []string{"free.example", "registered.example", "parked.example", "broken.example", "nodelegation.example"}
+	want := []string{StatusNXDomain, StatusRegistered, StatusParked, StatusError, StatusRegistered}
+	results := c.CheckAll(domains)
+	if len(results) != len(domains) {
+		t.Fatalf("CheckAll returned %d results for %d domains", len(results), len(domains))
+	}
+	for i, result := range results {
+		if result.Domain != domains[i] || result.Status != want[i] {
+			t.Errorf("result %d = %s %s, want %s %s", i, result.Domain, result.Status, domains[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package bitflip
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	CategoryBitflip       = "bitflip"
+	CategoryOmission      = "omission"
+	CategoryTransposition = "transposition"
+	CategoryHomoglyph     = "homoglyph"
+	CategoryTLDSwap       = "tld-swap"
+)
+
+// Categories lists all the candidate categories in the order of preference
+var Categories = []string{CategoryBitflip, CategoryOmission, CategoryTransposition, CategoryHomoglyph, CategoryTLDSwap}
+
+// Candidate is a domain name that differs from the target domain in a way that a client could end up requesting
+type Candidate struct {
+	Domain   string `json:"domain"`
+	Target   string `json:"target"`
+	Category string `json:"category"`
+	// Position is the byte offset of the change in the target domain
+	Position int `json:"position"`
+	// Bit is the index of the flipped bit, only set for bitflip candidates
+	Bit int `json:"bit"`
+}
+
+// ASCII lookalikes, note that these are applied on top of the lowercased domain
+var homoglyphs = map[string][]string{
+	"o":  {"0"},
+	"0":  {"o"},
+	"l":  {"1", "i"},
+	"i":  {"1", "l"},
+	"1":  {"l", "i"},
+	"m":  {"rn", "nn"},
+	"rn": {"m"},
+	"w":  {"vv"},
+	"vv": {"w"},
+	"d":  {"cl"},
+	"cl": {"d"},
+	"g":  {"q"},
+	"q":  {"g"},
+	"e":  {"3"},
+	"a":  {"4"},
+	"s":  {"5"},
+	"b":  {"6"},
+}
+
+var swapTLDs = []string{"com", "net", "org", "co", "io", "info", "biz", "cm", "om", "us", "eu", "de", "uk", "ai", "app"}
+
+// Generate returns the candidates of the requested categories for target domain.
+// Every candidate is a valid domain name with the same number of labels as the target, and each
+// candidate name is returned only once, with the first matching category in the order of Categories.
+func Generate(target string, categories []string) []Candidate {
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	seen := map[string]bool{target: true}
+	candidates := []Candidate{}
+	add := func(c Candidate) {
+		if seen[c.Domain] || !ValidDomain(c.Domain) || labelCount(c.Domain) != labelCount(target) {
+			return
+		}
+		seen[c.Domain] = true
+		candidates = append(candidates, c)
+	}
+	for _, category := range Categories {
+		if !contains(categories, category) {
+			continue
+		}
+		switch category {
+		case CategoryBitflip:
+			for _, c := range bitflips(target) {
+				add(c)
+			}
+		case CategoryOmission:
+			for _, c := range omissions(target) {
+				add(c)
+			}
+		case CategoryTransposition:
+			for _, c := range transpositions(target) {
+				add(c)
+			}
+		case CategoryHomoglyph:
+			for _, c := range homoglyphSwaps(target) {
+				add(c)
+			}
+		case CategoryTLDSwap:
+			for _, c := range tldSwaps(target) {
+				add(c)
+			}
+		}
+	}
+	return candidates
+}
+
+// GenerateAll generates the candidates for a list of targets, leaving out the candidates that are targets themselves
+func GenerateAll(targets []string, categories []string) []Candidate {
+	isTarget := map[string]bool{}
+	for _, t := range targets {
+		isTarget[strings.ToLower(strings.TrimSuffix(t, "."))] = true
+	}
+	seen := map[string]bool{}
+	candidates := []Candidate{}
+	for _, t := range targets {
+		for _, c := range Generate(t, categories) {
+			if isTarget[c.Domain] || seen[c.Domain] {
+				continue
+			}
+			seen[c.Domain] = true
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+// Analyze returns the candidate describing how domain differs from the target domain, with the first matching
+// category in the order of Categories. It returns false if domain isn't a candidate of the target.
+func Analyze(domain, target string) (Candidate, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, c := range Generate(target, Categories) {
+		if c.Domain == domain {
+			return c, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// Label returns the number of the label containing the byte offset position of domain, counted from the right so
+// that the top level domain is 1, and the offset of position in that label
+func Label(domain string, position int) (int, int) {
+	if position > len(domain) {
+		position = len(domain)
+	}
+	start := strings.LastIndex(domain[:position], ".") + 1
+	return strings.Count(domain[position:], ".") + 1, position - start
+}
+
+func bitflips(target string) []Candidate {
+	candidates := []Candidate{}
+	for pos := 0; pos < len(target); pos++ {
+		for bit := 0; bit < 8; bit++ {
+			flipped := target[pos] ^ (1 << bit)
+			// DNS names are case insensitive, so flipping the case bit doesn't produce a new name
+			if strings.ToLower(string(flipped)) == string(target[pos]) {
+				continue
+			}
+			domain := target[:pos] + string(flipped) + target[pos+1:]
+			candidates = append(candidates, Candidate{Domain: domain, Target: target, Category: CategoryBitflip, Position: pos, Bit: bit})
+		}
+	}
+	return candidates
+}
+
+func omissions(target string) []Candidate {
+	candidates := []Candidate{}
+	for pos := 0; pos < tldOffset(target); pos++ {
+		if target[pos] == '.' {
+			continue
+		}
+		candidates = append(candidates, Candidate{Domain: target[:pos] + target[pos+1:], Target: target, Category: CategoryOmission, Position: pos})
+	}
+	return candidates
+}
+
+func transpositions(target string) []Candidate {
+	candidates := []Candidate{}
+	for pos := 0; pos < tldOffset(target)-1; pos++ {
+		if target[pos] == '.' || target[pos+1] == '.' || target[pos] == target[pos+1] {
+			continue
+		}
+		domain := target[:pos] + string(target[pos+1]) + string(target[pos]) + target[pos+2:]
+		candidates = append(candidates, Candidate{Domain: domain, Target: target, Category: CategoryTransposition, Position: pos})
+	}
+	return candidates
+}
+
+func homoglyphSwaps(target string) []Candidate {
+	candidates := []Candidate{}
+	glyphs := make([]string, 0, len(homoglyphs))
+	for g := range homoglyphs {
+		glyphs = append(glyphs, g)
+	}
+	sort.Strings(glyphs)
+	end := tldOffset(target)
+	for pos := 0; pos < end; pos++ {
+		for _, from := range glyphs {
+			if pos+len(from) > end || target[pos:pos+len(from)] != from {
+				continue
+			}
+			for _, to := range homoglyphs[from] {
+				domain := target[:pos] + to + target[pos+len(from):]
+				candidates = append(candidates, Candidate{Domain: domain, Target: target, Category: CategoryHomoglyph, Position: pos})
+			}
+		}
+	}
+	return candidates
+}
+
+func tldSwaps(target string) []Candidate {
+	candidates := []Candidate{}
+	offset := tldOffset(target)
+	if offset == 0 {
+		return candidates
+	}
+	for _, tld := range swapTLDs {
+		candidates = append(candidates, Candidate{Domain: target[:offset] + tld, Target: target, Category: CategoryTLDSwap, Position: offset})
+	}
+	return candidates
+}
+
+// tldOffset returns the offset of the last label of the domain
+func tldOffset(domain string) int {
+	return strings.LastIndex(domain, ".") + 1
+}
+
+func labelCount(domain string) int {
+	return len(strings.Split(domain, "."))
+}
+
+// ValidDomain checks that the domain consists of valid hostname labels
+func ValidDomain(domain string) bool {
+	if len(domain) == 0 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if !validLabel(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package bitflip
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	candidates := Generate("GOOGLE.com.", Categories)
+	if len(candidates) == 0 {
+		t.Fatal("no candidates for google.com")
+	}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if c.Target != "google.com" {
+			t.Errorf("%s: target %s, want google.com", c.Domain, c.Target)
+		}
+		if seen[c.Domain] {
+			t.Errorf("%s was generated more than once", c.Domain)
+		}
+		seen[c.Domain] = true
+		if !ValidDomain(c.Domain) || labelCount(c.Domain) != 2 {
+			t.Errorf("%s isn't a valid two label domain", c.Domain)
+		}
+		// Flipping the case bit of a letter only changes the case of the target
+		if strings.EqualFold(c.Domain, "google.com") || (c.Category == CategoryBitflip && c.Bit == 5) {
+			t.Errorf("%s is the target in another case", c.Domain)
+		}
+	}
+}
+
+func TestGenerateBitflips(t *testing.T) {
+	domains := []string{}
+	for _, c := range Generate("a.co", []string{CategoryBitflip}) {
+		if c.Position == 0 {
+			domains = append(domains, c.Domain)
+		}
+	}
+	// Of the flips of 'a' (0x61), bit 5 is the case, and the bits 0, 6 and 7 aren't valid hostname characters
+	if got := strings.Join(domains, ","); got != "c.co,e.co,i.co,q.co" {
+		t.Errorf("bitflips of the first label %s, want c.co,e.co,i.co,q.co", got)
+	}
+}
+
+func TestGenerateFirstMatch(t *testing.T) {
+	tests := []struct {
+		target     string
+		categories []string
+		domain     string
+		category   string
+		position   int
+	}{
+		// google.cm is both a bitflip and a swapped top level domain of google.co
+		{"google.co", Categories, "google.cm", CategoryBitflip, 8},
+		{"google.co", []string{CategoryTLDSwap, CategoryBitflip}, "google.cm", CategoryBitflip, 8},
+		{"google.co", []string{CategoryTLDSwap}, "google.cm", CategoryTLDSwap, 7},
+		// Omitting either o of google results in the same name
+		{"google.com", []string{CategoryOmission}, "gogle.com", CategoryOmission, 1},
+	}
+	for _, test := range tests {
+		found := 0
+		for _, c := range Generate(test.target, test.categories) {
+			if c.Domain != test.domain {
+				continue
+			}
+			found++
+			if c.Category != test.category || c.Position != test.position {
+				t.Errorf("%s %v: %s is %s at %d, want %s at %d", test.target, test.categories, c.Domain, c.Category, c.Position, test.category, test.position)
+			}
+		}
+		if found != 1 {
+			t.Errorf("%s %v: %s was generated %d times, want once", test.target, test.categories, test.domain, found)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		domain   string
+		target   string
+		ok       bool
+		category string
+		position int
+		bit      int
+	}{
+		{"coogle.com", "google.com", true, CategoryBitflip, 0, 2},
+		{"COOGLE.com.", "google.com.", true, CategoryBitflip, 0, 2},
+		{"google.cm", "google.co", true, CategoryBitflip, 8, 1},
+		{"gogle.com", "google.com", true, CategoryOmission, 1, 0},
+		{"goolge.com", "google.com", true, CategoryTransposition, 3, 0},
+		{"g0ogle.com", "google.com", true, CategoryHomoglyph, 1, 0},
+		{"google.net", "google.com", true, CategoryTLDSwap, 7, 0},
+		{"Google.com", "google.com", false, "", 0, 0},
+		{"gooogle.com", "google.com", false, "", 0, 0},
+		{"c00gle.com", "google.com", false, "", 0, 0},
+		{"mail.coogle.com", "google.com", false, "", 0, 0},
+	}
+	for _, test := range tests {
+		c, ok := Analyze(test.domain, test.target)
+		if ok != test.ok || c.Category != test.category || c.Position != test.position || c.Bit != test.bit {
+			t.Errorf("Analyze(%s, %s) = %+v %t, want %s at %d bit %d", test.domain, test.target, c, ok, test.category, test.position, test.bit)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		domain   string
+		position int
+		label    int
+		offset   int
+	}{
+		{"google.com", 0, 2, 0},
+		{"google.com", 5, 2, 5},
+		{"google.com", 7, 1, 0},
+		{"mail.google.com", 6, 2, 1},
+		{"google.com", 20, 1, 3},
+	}
+	for _, test := range tests {
+		label, offset := Label(test.domain, test.position)
+		if label != test.label || offset != test.offset {
+			t.Errorf("Label(%s, %d) = %d, %d, want %d, %d", test.domain, test.position, label, offset, test.label, test.offset)
+		}
+	}
+}
+
+func TestValidDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"google.com", true},
+		{"xn--ggle-0nda.com", true},
+		{"g00gle.com", true},
+		{"", false},
+		{"google..com", false},
+		{"-google.com", false},
+		{"google-.com", false},
+		{"Google.com", false},
+		{"goo_gle.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+	}
+	for _, test := range tests {
+		if got := ValidDomain(test.domain); got != test.valid {
+			t.Errorf("ValidDomain(%s) = %t, want %t", test.domain, got, test.valid)
+		}
+	}
+}
@@ -8,19 +8,17 @@ import (
 
 // ChallengeProvider implements go-acme/lego Provider interface which is used for ACME DNS challenge handling
 type ChallengeProvider struct {
-	servers []CertainlyNS
+	server CertainlyNS
 }
 
 // NewChallengeProvider creates a new instance of ChallengeProvider
-func NewChallengeProvider(servers []CertainlyNS) ChallengeProvider {
-	return ChallengeProvider{servers: servers}
+func NewChallengeProvider(server CertainlyNS) ChallengeProvider {
+	return ChallengeProvider{server: server}
 }
 
 // Present is used for making the ACME DNS challenge token available for DNS
 func (c *ChallengeProvider) Present(ctx context.Context, challenge acme.Challenge) error {
-	for _, s := range c.servers {
-		s.SetChallengeToken(challenge.DNS01TXTRecordName(), challenge.DNS01KeyAuthorization())
-	}
+	c.server.SetChallengeToken(challenge.DNS01TXTRecordName(), challenge.DNS01KeyAuthorization())
 	return nil
 }
 
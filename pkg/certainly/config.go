@@ -2,7 +2,10 @@ package certainly
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 
 	"github.com/BurntSushi/toml"
 )
@@ -22,20 +25,86 @@ func FileIsAccessible(fname string) bool {
 
 func readTomlConfig(fname string) (CertainlyCFG, error) {
 	var conf CertainlyCFG
-	_, err := toml.DecodeFile(fname, &conf)
+	md, err := toml.DecodeFile(fname, &conf)
 	if err != nil {
 		// Return with config file parsing errors from toml package
 		return conf, err
 	}
-	return prepareConfig(conf)
+	// The `_file` settings aren't part of the schema, so they're looked up from the schemaless decoding
+	raw := map[string]interface{}{}
+	if _, err := toml.DecodeFile(fname, &raw); err != nil {
+		return conf, err
+	}
+	if errs := applyOverrides(&conf, raw); len(errs) > 0 {
+		return conf, errs.locate(fname)
+	}
+	conf, err = prepareConfig(conf)
+	if err != nil {
+		return conf, err
+	}
+	if errs := validateConfig(conf, md); len(errs) > 0 {
+		return conf, errs.locate(fname)
+	}
+	return conf, nil
 }
 
-// prepareConfig checks that mandatory values exist, and can be used to set default values in the future
+// prepareConfig sets the default values, the values are checked afterwards in validateConfig
 func prepareConfig(conf CertainlyCFG) (CertainlyCFG, error) {
 	// Make sure we have a default value for the ACME cache directory
 	if conf.General.ACMECacheDir == "" {
 		conf.General.ACMECacheDir = "api-certs"
 	}
+	// DNSSEC keys are stored next to the certificates by default
+	if conf.NS.DNSSECKeyDir == "" {
+		conf.NS.DNSSECKeyDir = filepath.Join(filepath.Dir(filepath.Clean(conf.General.ACMECacheDir)), "dnssec")
+	}
+	// The DNS flag day 2020 recommendation, avoids IP fragmentation on most networks
+	if conf.NS.EDNSMaxUDPSize == 0 {
+		conf.NS.EDNSMaxUDPSize = 1232
+	}
+	if conf.General.ShutdownTimeout <= 0 {
+		conf.General.ShutdownTimeout = 30
+	}
+	if conf.Events.Path == "" {
+		conf.Events.Path = "events.db"
+	}
+	if len(conf.Resolver.Upstreams) == 0 {
+		conf.Resolver.Upstreams = []string{"8.8.8.8:53"}
+	}
+	if conf.Resolver.Timeout == 0 {
+		conf.Resolver.Timeout = 5
+	}
+	if conf.Events.SessionWindow == 0 {
+		conf.Events.SessionWindow = 600
+	}
+	// Listen on the general IP address and the nameserver port by default
+	if len(conf.NS.Listen) == 0 {
+		conf.NS.Listen = []string{net.JoinHostPort(conf.General.IP, conf.NS.Port)}
+	}
+	if conf.RateLimit.ResponsesPerSecond == 0 && conf.RateLimit.AllPerSecond == 0 {
+		conf.RateLimit.ResponsesPerSecond = 20
+	}
+	if conf.RateLimit.Window == 0 {
+		conf.RateLimit.Window = 15
+	}
+	if conf.RateLimit.IPv4Prefix == 0 {
+		conf.RateLimit.IPv4Prefix = 24
+	}
+	if conf.RateLimit.IPv6Prefix == 0 {
+		conf.RateLimit.IPv6Prefix = 56
+	}
+	if conf.RateLimit.LogInterval == 0 {
+		conf.RateLimit.LogInterval = 60
+	}
+	if conf.Classify.SweepThreshold == 0 {
+		conf.Classify.SweepThreshold = 50
+	}
+	if conf.API.Listen == "" {
+		conf.API.Listen = "127.0.0.1:8053"
+	}
+	if len(conf.API.AllowFrom) == 0 {
+		conf.API.AllowFrom = Cidrslice{"127.0.0.1/32", "::1/128"}
+	}
 
 	return conf, nil
 }
@@ -54,7 +123,37 @@ func ReadConfig(configFile string) (CertainlyCFG, string, error) {
 		err = fmt.Errorf("configuration file not found")
 	}
 	if err != nil {
-		err = fmt.Errorf("encountered an error while trying to read configuration file:  %s\n", err)
+		err = fmt.Errorf("encountered an error while trying to read configuration file:  %w\n", err)
 	}
 	return config, usedConfigFile, err
 }
+
+// KeepStartupSettings copies the settings that only take effect on startup from the running configuration to the
+// new one, so that a reloaded configuration matches what is actually running. It returns the names of the settings
+// that were changed in the new configuration and need a restart.
+func KeepStartupSettings(running *CertainlyCFG, config *CertainlyCFG) []string {
+	changed := []string{}
+	keep(&changed, "general.ip", running.General.IP, &config.General.IP)
+	keep(&changed, "general.cert_dir", running.General.ACMECacheDir, &config.General.ACMECacheDir)
+	keep(&changed, "ns.port", running.NS.Port, &config.NS.Port)
+	keep(&changed, "ns.listen", running.NS.Listen, &config.NS.Listen)
+	keep(&changed, "ns.protocol", running.NS.Proto, &config.NS.Proto)
+	keep(&changed, "ns.default_domain", running.NS.DefaultDomain, &config.NS.DefaultDomain)
+	keep(&changed, "ns.dnssec", running.NS.DNSSEC, &config.NS.DNSSEC)
+	keep(&changed, "ns.dnssec_key_dir", running.NS.DNSSECKeyDir, &config.NS.DNSSECKeyDir)
+	keep(&changed, "ns.tsig_keys", running.NS.TSIGKeys, &config.NS.TSIGKeys)
+	keep(&changed, "httpd.http_port", running.HTTPD.HTTPPort, &config.HTTPD.HTTPPort)
+	keep(&changed, "httpd.https_port", running.HTTPD.HTTPSPort, &config.HTTPD.HTTPSPort)
+	keep(&changed, "logconfig", running.Logconfig, &config.Logconfig)
+	keep(&changed, "events", running.Events, &config.Events)
+	keep(&changed, "resolver", running.Resolver, &config.Resolver)
+	keep(&changed, "api", running.API, &config.API)
+	return changed
+}
+
+func keep[T any](changed *[]string, name string, running T, config *T) {
+	if !reflect.DeepEqual(running, *config) {
+		*changed = append(*changed, name)
+		*config = running
+	}
+}
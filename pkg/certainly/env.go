@@ -0,0 +1,185 @@
+package certainly
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix is the prefix of the environment variables overriding the configuration, for example
+// CERTAINLY_NOTIFICATION_SLACK_TOKEN overrides slack_token in the [notification] section
+const envPrefix = "CERTAINLY_"
+
+// redacted replaces the secrets in the printed configuration
+const redacted = "[redacted]"
+
+// setting is a single configuration value, named by the toml tags of CertainlyCFG
+type setting struct {
+	// key is the setting in dotted form, for example notification.slack_token
+	key    string
+	field  reflect.Value
+	secret bool
+}
+
+// settings returns every setting of the configuration. The fields of the sections are settable through the returned values.
+func settings(conf *CertainlyCFG) []setting {
+	list := []setting{}
+	v := reflect.ValueOf(conf).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.Type.Kind() != reflect.Struct {
+			list = append(list, setting{tomlName(sf), v.Field(i), sf.Tag.Get("secret") == "true"})
+			continue
+		}
+		section := v.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			f := section.Type().Field(j)
+			list = append(list, setting{tomlName(sf) + "." + tomlName(f), section.Field(j), f.Tag.Get("secret") == "true"})
+		}
+	}
+	return list
+}
+
+func tomlName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("toml"), ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(sf.Name)
+}
+
+// envName returns the name of the environment variable overriding the setting
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// applyOverrides reads the string settings from the files named by the `_file` settings, and then applies the
+// CERTAINLY_* environment variables and the CERTAINLY_*_FILE variables, in that order. raw is the configuration
+// file decoded without a schema.
+func applyOverrides(conf *CertainlyCFG, raw map[string]interface{}) ConfigErrors {
+	errs := ConfigErrors{}
+	for _, s := range settings(conf) {
+		isString := s.field.Kind() == reflect.String
+		if fname, ok := rawValue(raw, s.key+"_file").(string); ok && isString {
+			if err := readSecretFile(s.field, fname); err != nil {
+				errs.add(s.key+"_file", fname, "%s", err)
+			}
+		}
+		name := envName(s.key)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setValue(s.field, value); err != nil {
+				errs.add(name, "", "invalid value for %s: %s", s.key, err)
+			}
+		}
+		if fname, ok := os.LookupEnv(name + "_FILE"); ok && isString {
+			if err := readSecretFile(s.field, fname); err != nil {
+				errs.add(name+"_FILE", "", "%s", err)
+			}
+		}
+	}
+	return errs
+}
+
+// isFileSetting returns true if the key is the `_file` variant of a string setting
+func isFileSetting(key string) bool {
+	base, ok := strings.CutSuffix(key, "_file")
+	if !ok {
+		return false
+	}
+	for _, s := range settings(&CertainlyCFG{}) {
+		if s.key == base && s.field.Kind() == reflect.String {
+			return true
+		}
+	}
+	return false
+}
+
+func rawValue(raw map[string]interface{}, key string) interface{} {
+	var value interface{} = raw
+	for _, part := range strings.Split(key, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = table[part]
+	}
+	return value
+}
+
+// readSecretFile sets the field to the contents of the file, without the trailing newline
+func readSecretFile(field reflect.Value, fname string) error {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	field.SetString(strings.TrimRight(string(data), "\r\n"))
+	return nil
+}
+
+// setValue parses the environment variable value to the field. Lists of strings can be given comma separated,
+// and the other lists and tables as TOML values, for example {"coogle.com" = "google.com"}.
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+		return nil
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+		return nil
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			list := reflect.MakeSlice(field.Type(), 0, 0)
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					list = reflect.Append(list, reflect.ValueOf(v))
+				}
+			}
+			field.Set(list)
+			return nil
+		}
+	}
+	holder := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "V", Type: field.Type(), Tag: `toml:"v"`}}))
+	if _, err := toml.Decode("v = "+value, holder.Interface()); err != nil {
+		return fmt.Errorf("expected a TOML value: %w", err)
+	}
+	field.Set(holder.Elem().Field(0))
+	return nil
+}
+
+// Redacted returns a copy of the configuration with the secrets replaced, for printing it out
+func Redacted(conf CertainlyCFG) CertainlyCFG {
+	for _, s := range settings(&conf) {
+		if !s.secret {
+			continue
+		}
+		switch s.field.Kind() {
+		case reflect.String:
+			if s.field.String() != "" {
+				s.field.SetString(redacted)
+			}
+		case reflect.Map:
+			// The maps are shared with the original configuration, so replace them instead of modifying
+			m := reflect.MakeMap(s.field.Type())
+			iter := s.field.MapRange()
+			for iter.Next() {
+				m.SetMapIndex(iter.Key(), reflect.ValueOf(redacted))
+			}
+			s.field.Set(m)
+		}
+	}
+	return conf
+}
@@ -0,0 +1,47 @@
+package certainly
+
+import "time"
+
+// Event is a single captured interaction on any of the listening protocols
+type Event struct {
+	ID       string    `json:"id"`
+	Protocol string    `json:"protocol"`
+	Time     time.Time `json:"time"`
+	// RemoteAddr is the address the interaction originated from
+	RemoteAddr string `json:"remoteAddr"`
+	// ServerName is the TLS SNI, HTTP Host or the DNS question name
+	ServerName string `json:"serverName,omitempty"`
+	TLS        bool   `json:"tls"`
+	// CorrelationID is the UUID handed out to the client, eg. the DNS CNAME target or the HTTP CERTAINLY_HASH
+	CorrelationID string `json:"correlationID,omitempty"`
+	// SessionID links together the events of a single client across the protocols
+	SessionID string `json:"sessionID,omitempty"`
+	// Data holds the protocol specific payload
+	Data map[string]string `json:"data,omitempty"`
+	// Flip describes how the requested domain differs from the rewrite target, if the name is under a rewrite source
+	Flip *Flip `json:"flip,omitempty"`
+}
+
+// Flip describes how a captured apex domain differs from the domain it was registered for
+type Flip struct {
+	// Domain is the captured apex domain, the rewrite source
+	Domain string `json:"domain"`
+	// Target is the original domain, the rewrite target
+	Target string `json:"target"`
+	// Category is the bitflip candidate category, empty if the domain isn't a single change of the target. The
+	// fields below are only set when the category is.
+	Category string `json:"category,omitempty"`
+	// Position is the byte offset of the change in the target domain
+	Position int `json:"position"`
+	// Bit is the index of the flipped bit, only set for the bitflip category
+	Bit int `json:"bit"`
+	// Label is the number of the changed label counted from the right, the top level domain being 1
+	Label int `json:"label"`
+	// LabelOffset is the byte offset of the change in the label
+	LabelOffset int `json:"labelOffset"`
+}
+
+// NewEvent creates a new Event with the payload map initialized
+func NewEvent(protocol, remoteAddr string) *Event {
+	return &Event{Protocol: protocol, RemoteAddr: remoteAddr, Data: make(map[string]string)}
+}
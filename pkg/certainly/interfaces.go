@@ -1,12 +1,43 @@
 package certainly
 
+import "context"
+
 type CertainlyNS interface {
-	Start(errorChannel chan error)
-	SetNotifyStartedFunc(func())
+	Reloadable
+	Listeners() []Listener
 	SetChallengeToken(domain, token string)
 	ParseRecords()
+	ListDomains() []string
+	AddDomain(domain string) error
+	DeleteDomain(domain string) error
+	ListRecords(name string) []string
+	AddRecord(record string) error
+	DeleteRecord(record string) error
 }
 
 type Notification interface {
 	Notify(protocol string, message string)
 }
+
+type EventSink interface {
+	Store(event Event) error
+	Close() error
+}
+
+// Listener is a single network listener of one of the servers, run by the lifecycle manager
+type Listener interface {
+	// Name identifies the listener in the logs and health reports, for example "smtp 0.0.0.0:25"
+	Name() string
+	// Listen binds the listening socket, it's called again to restart a failed listener
+	Listen() error
+	// Serve serves the bound socket and blocks until the listener is shut down or fails
+	Serve() error
+	// Shutdown stops accepting new connections and waits for the open ones to finish until the context is done
+	Shutdown(ctx context.Context) error
+}
+
+// Reloadable is implemented by the components that apply a changed configuration at runtime
+type Reloadable interface {
+	// Reload applies the configuration, or returns an error without changing anything if it can't be applied
+	Reload(config *CertainlyCFG) error
+}
@@ -0,0 +1,68 @@
+package certainly
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// The classes of the DNS query sources
+const (
+	ClassPublicResolver = "public-resolver"
+	ClassISPResolver    = "isp-resolver"
+	ClassScanner        = "scanner"
+	ClassEndHost        = "end-host"
+	ClassUnknown        = "unknown"
+)
+
+// PrefixEntry is a network of known query sources
+type PrefixEntry struct {
+	Class string
+	// Label names the operator of the network, for example google
+	Label   string
+	Network *net.IPNet
+}
+
+// ParsePrefixList reads a list of "class label prefix" lines, for example "public-resolver google 8.8.8.0/24".
+// Empty lines and comments starting with # are skipped. The errors contain the name and the line number.
+func ParsePrefixList(r io.Reader, name string) ([]PrefixEntry, error) {
+	entries := []PrefixEntry{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"class label prefix\"", name, line)
+		}
+		switch fields[0] {
+		case ClassPublicResolver, ClassISPResolver, ClassScanner, ClassEndHost:
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown class %q", name, line, fields[0])
+		}
+		_, network, err := net.ParseCIDR(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		entries = append(entries, PrefixEntry{Class: fields[0], Label: fields[1], Network: network})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// ReadPrefixFile reads a prefix list from a file
+func ReadPrefixFile(filename string) ([]PrefixEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePrefixList(f, filename)
+}
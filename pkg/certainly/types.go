@@ -1,21 +1,38 @@
 package certainly
 
 // DNSConfig holds the config structure
+// The settings tagged as secret are redacted when the configuration is printed
 type CertainlyCFG struct {
-	General         general
-	NS              nameserver
+	General         general           `toml:"general"`
+	NS              nameserver        `toml:"ns"`
 	Rewrites        map[string]string `toml:"rewrites"`
-	Logconfig       logconfig
-	Notification    notifications
-	HTTPD           httpd
+	Logconfig       logconfig         `toml:"logconfig"`
+	Notification    notifications     `toml:"notification"`
+	HTTPD           httpd             `toml:"httpd"`
 	HTTPDInjections map[string]string `toml:"httpd_injection_templates"`
+	Events          events            `toml:"events"`
+	Resolver        resolverconfig    `toml:"resolver"`
+	RateLimit       ratelimit         `toml:"ratelimit"`
+	Classify        classification    `toml:"classify"`
+	API             api               `toml:"api"`
 }
 
 type httpd struct {
-	HTTPPort                  string   `toml:"http_port"`
-	HTTPSPort                 string   `toml:"https_port"`
-	InjectionTemplateFilepath string   `toml:"injection_template_filepath"`
-	InjectionFilters          []string `toml:"injection_filters"`
+	HTTPPort                  string          `toml:"http_port"`
+	HTTPSPort                 string          `toml:"https_port"`
+	InjectionTemplateFilepath string          `toml:"injection_template_filepath"`
+	InjectionFilters          []string        `toml:"injection_filters"`
+	InjectionRules            []InjectionRule `toml:"injection_rules"`
+}
+
+// InjectionRule injects a template to the proxied responses for the matching request URIs
+type InjectionRule struct {
+	Name  string `toml:"name"`
+	Regex string `toml:"regex"`
+	// Template is the template file in the injection template directory
+	Template string `toml:"template"`
+	// Rules with a higher priority are evaluated first, rules with the same priority in the order they are listed
+	Priority int `toml:"priority"`
 }
 
 type general struct {
@@ -24,20 +41,54 @@ type general struct {
 	ACMECacheDir     string   `toml:"cert_dir"`
 	TLSFilters       []string `toml:"tls_filters"`
 	TLSUpstreamCheck bool     `toml:"tls_upstream_check"`
+	ShutdownTimeout  int      `toml:"shutdown_timeout"`
 }
 
 // Config file nameserver section
 type nameserver struct {
-	Port          string   `toml:"port"`
-	Proto         string   `toml:"protocol"`
-	DefaultDomain string   `toml:"default_domain"`
-	Domains       []string `toml:"domains"`
-	Nsname        string   `toml:"nsname"`
-	Nsadmin       string   `toml:"nsadmin"`
-	NSResponseIP  string   `toml:"ns_response_ip"`
-	Debug         bool     `toml:"debug"`
-	StaticRecords []string `toml:"records"`
-	Ttl           int      `toml:"ttl"`
+	Port              string              `toml:"port"`
+	Listen            []string            `toml:"listen"`
+	Proto             string              `toml:"protocol"`
+	DefaultDomain     string              `toml:"default_domain"`
+	Domains           []string            `toml:"domains"`
+	Nsname            string              `toml:"nsname"`
+	Nsadmin           string              `toml:"nsadmin"`
+	NSResponseIP      string              `toml:"ns_response_ip"`
+	NSResponseIP6     string              `toml:"ns_response_ip6"`
+	Debug             bool                `toml:"debug"`
+	StaticRecords     []string            `toml:"records"`
+	ZoneFiles         map[string][]string `toml:"zonefiles"`
+	Ttl               int                 `toml:"ttl"`
+	Profiles          []NSProfile         `toml:"profiles"`
+	DNSSEC            bool                `toml:"dnssec"`
+	DNSSECKeyDir      string              `toml:"dnssec_key_dir"`
+	TSIGKeys          map[string]string   `toml:"tsig_keys" secret:"true"`
+	AllowTransfer     Cidrslice           `toml:"allow_transfer"`
+	NotifySecondaries []string            `toml:"notify_secondaries"`
+	// EDNSMaxUDPSize is the largest UDP response sent to the clients advertising a larger EDNS buffer
+	EDNSMaxUDPSize int `toml:"edns_max_udp_size"`
+}
+
+// NSProfile defines how the nameserver responds for the matching domains
+type NSProfile struct {
+	Name string `toml:"name"`
+	// Domains lists the apex domains the profile applies to, including their subdomains
+	Domains []string `toml:"domains"`
+	// Regexes are matched against the question name without the trailing dot
+	Regexes []string `toml:"regexes"`
+	// Types lists the record types that are synthesized
+	Types []string `toml:"types"`
+	A     string   `toml:"a"`
+	AAAA  string   `toml:"aaaa"`
+	MX    string   `toml:"mx"`
+	// CNAME target to use instead of a random UUID subdomain of the default domain
+	CNAME string `toml:"cname"`
+	// Passthrough answers with the upstream records of the rewrite target instead of synthesizing records
+	Passthrough bool `toml:"passthrough"`
+	// ReplaceTypes lists the record types that are synthesized in passthrough mode if they exist upstream
+	ReplaceTypes []string `toml:"replace_types"`
+	// ReplaceLabels lists the subdomains that are synthesized in passthrough mode
+	ReplaceLabels []string `toml:"replace_labels"`
 }
 
 // Logging config
@@ -51,7 +102,7 @@ type logconfig struct {
 // Notification config
 type notifications struct {
 	Slack               bool     `toml:"slack"`
-	SlackToken          string   `toml:"slack_token"`
+	SlackToken          string   `toml:"slack_token" secret:"true"`
 	SlackDefaultChannel string   `toml:"slack_default_channel"`
 	SlackHTTPChannel    string   `toml:"slack_http_channel"`
 	SlackDNSChannel     string   `toml:"slack_dns_channel"`
@@ -67,6 +118,50 @@ type notifications struct {
 	IMAPFilters         []string `toml:"imap_filters"`
 }
 
+// Upstream resolver config
+type resolverconfig struct {
+	Upstreams []string `toml:"upstreams"`
+	Timeout   int      `toml:"timeout"`
+	Retries   int      `toml:"retries"`
+	Cache     bool     `toml:"cache"`
+}
+
+// Event store config
+type events struct {
+	Store         bool   `toml:"store"`
+	Path          string `toml:"path"`
+	SessionWindow int    `toml:"session_window"`
+}
+
+// DNS response rate limiting config
+type ratelimit struct {
+	Enabled            bool      `toml:"enabled"`
+	ResponsesPerSecond int       `toml:"responses_per_second"`
+	AllPerSecond       int       `toml:"all_per_second"`
+	Window             int       `toml:"window"`
+	Slip               int       `toml:"slip"`
+	IPv4Prefix         int       `toml:"ipv4_prefix"`
+	IPv6Prefix         int       `toml:"ipv6_prefix"`
+	Exempt             Cidrslice `toml:"exempt"`
+	LogInterval        int       `toml:"log_interval"`
+}
+
+// DNS query source classification config
+type classification struct {
+	Enabled        bool   `toml:"enabled"`
+	PrefixList     string `toml:"prefix_list"`
+	SweepThreshold int    `toml:"sweep_threshold"`
+}
+
+// Admin API config
+type api struct {
+	Enabled   bool      `toml:"enabled"`
+	Listen    string    `toml:"listen"`
+	TokenHash string    `toml:"token_hash" secret:"true"`
+	AllowFrom Cidrslice `toml:"allow_from"`
+	StateFile string    `toml:"state_file"`
+}
+
 // UpstreamNSRecord is used for target nameserver records
 type UpstreamNSRecord struct {
 	Addr string
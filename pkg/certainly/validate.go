@@ -0,0 +1,359 @@
+package certainly
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/happycakefriends/certainly/pkg/resolver"
+)
+
+// ConfigError is a single problem found while validating the configuration
+type ConfigError struct {
+	File string
+	// Line is the line of the setting in the configuration file, or 0 if it couldn't be found
+	Line int
+	// Key is the setting in dotted form, for example general.tls_filters
+	Key     string
+	Message string
+	// value is the offending value, used for finding the line
+	value string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Key, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
+
+// ConfigErrors holds all the problems found in the configuration
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (errs *ConfigErrors) add(key, value, format string, args ...interface{}) {
+	*errs = append(*errs, ConfigError{Key: key, Message: fmt.Sprintf(format, args...), value: value})
+}
+
+// validateConfig checks the whole configuration and returns all the problems found, without line numbers
+func validateConfig(conf CertainlyCFG, md toml.MetaData) ConfigErrors {
+	errs := ConfigErrors{}
+	for _, key := range md.Undecoded() {
+		if !isFileSetting(key.String()) {
+			errs.add(key.String(), "", "unknown setting")
+		}
+	}
+
+	if conf.General.IP != "" && net.ParseIP(conf.General.IP) == nil {
+		errs.add("general.ip", conf.General.IP, "%q is not an IP address", conf.General.IP)
+	}
+	checkRegexes(&errs, "general.tls_filters", conf.General.TLSFilters)
+
+	validateNS(&errs, conf)
+
+	// The default domain and the zone file domains are managed as well
+	managed := append([]string{conf.NS.DefaultDomain}, conf.NS.Domains...)
+	for d := range conf.NS.ZoneFiles {
+		managed = append(managed, d)
+	}
+	for _, source := range sortedKeys(conf.Rewrites) {
+		target := conf.Rewrites[source]
+		if !inAnyDomain(source, managed) {
+			errs.add("rewrites", source, "rewrite source %s is not under any of ns.domains", source)
+		}
+		if _, ok := dns.IsDomainName(target); !ok || target == "" {
+			errs.add("rewrites", source, "rewrite target %q is not a valid domain name", target)
+		}
+	}
+
+	checkRegexes(&errs, "httpd.injection_filters", conf.HTTPD.InjectionFilters)
+	for i, rule := range conf.HTTPD.InjectionRules {
+		key := fmt.Sprintf("httpd.injection_rules[%d]", i)
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			errs.add(key+".regex", rule.Regex, "invalid regex %q: %s", rule.Regex, err)
+		}
+		if rule.Template == "" {
+			errs.add(key+".template", "", "not set")
+		} else if _, err := os.Stat(filepath.Join(conf.HTTPD.InjectionTemplateFilepath, rule.Template)); err != nil {
+			errs.add(key+".template", rule.Template, "template file: %s", err)
+		}
+	}
+	for _, rule := range sortedKeys(conf.HTTPDInjections) {
+		if _, err := regexp.Compile(rule); err != nil {
+			errs.add("httpd_injection_templates", rule, "invalid regex %q: %s", rule, err)
+		}
+		template := conf.HTTPDInjections[rule]
+		if _, err := os.Stat(filepath.Join(conf.HTTPD.InjectionTemplateFilepath, template)); err != nil {
+			errs.add("httpd_injection_templates", template, "template file: %s", err)
+		}
+	}
+
+	checkRegexes(&errs, "notification.http_filters", conf.Notification.HTTPFilters)
+	checkRegexes(&errs, "notification.dns_filters", conf.Notification.DNSFilters)
+	checkRegexes(&errs, "notification.smtp_filters", conf.Notification.SMTPFilters)
+	checkRegexes(&errs, "notification.imap_filters", conf.Notification.IMAPFilters)
+
+	validateRateLimit(&errs, conf)
+	validateResolver(&errs, conf)
+
+	if conf.Classify.SweepThreshold < 0 {
+		errs.add("classify.sweep_threshold", "", "can't be negative")
+	}
+	if conf.Classify.PrefixList != "" {
+		if _, err := ReadPrefixFile(conf.Classify.PrefixList); err != nil {
+			errs.add("classify.prefix_list", conf.Classify.PrefixList, "%s", err)
+		}
+	}
+
+	if conf.API.Enabled {
+		if conf.API.TokenHash == "" {
+			errs.add("api.token_hash", "", "the admin API is enabled but token_hash is not set")
+		} else if _, err := bcrypt.Cost([]byte(conf.API.TokenHash)); err != nil {
+			errs.add("api.token_hash", conf.API.TokenHash, "not a bcrypt hash: %s", err)
+		}
+		if _, _, err := net.SplitHostPort(conf.API.Listen); err != nil {
+			errs.add("api.listen", conf.API.Listen, "%s", err)
+		}
+	}
+	if err := conf.API.AllowFrom.IsValid(); err != nil {
+		errs.add("api.allow_from", "", "%s", err)
+	}
+	return errs
+}
+
+func validateNS(errs *ConfigErrors, conf CertainlyCFG) {
+	ns := conf.NS
+	if ns.DefaultDomain == "" {
+		errs.add("ns.default_domain", "", "not set")
+	} else if _, ok := dns.IsDomainName(ns.DefaultDomain); !ok {
+		errs.add("ns.default_domain", ns.DefaultDomain, "%q is not a valid domain name", ns.DefaultDomain)
+	}
+	for _, d := range ns.Domains {
+		if _, ok := dns.IsDomainName(d); !ok || d == "" {
+			errs.add("ns.domains", d, "%q is not a valid domain name", d)
+		}
+	}
+	responseIP := net.ParseIP(ns.NSResponseIP)
+	if responseIP == nil || responseIP.To4() == nil {
+		errs.add("ns.ns_response_ip", ns.NSResponseIP, "%q is not an IPv4 address", ns.NSResponseIP)
+	}
+	responseIP6 := net.ParseIP(ns.NSResponseIP6)
+	if ns.NSResponseIP6 != "" && (responseIP6 == nil || responseIP6.To4() != nil) {
+		errs.add("ns.ns_response_ip6", ns.NSResponseIP6, "%q is not an IPv6 address", ns.NSResponseIP6)
+	}
+	if _, ok := dns.IsDomainName(ns.Nsname); !ok || ns.Nsname == "" {
+		errs.add("ns.nsname", ns.Nsname, "%q is not a valid domain name", ns.Nsname)
+	}
+	switch strings.TrimRight(ns.Proto, "46") {
+	case "udp", "tcp", "both":
+	default:
+		errs.add("ns.protocol", ns.Proto, "%q is not one of udp, tcp or both, optionally followed by 4 or 6", ns.Proto)
+	}
+	for _, addr := range ns.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs.add("ns.listen", addr, "%s", err)
+		}
+	}
+
+	nsname := strings.ToLower(dns.Fqdn(ns.Nsname))
+	for _, v := range ns.StaticRecords {
+		rr, err := dns.NewRR(strings.ToLower(v))
+		if err != nil {
+			errs.add("ns.records", v, "%s", err)
+			continue
+		}
+		if rr == nil || rr.Header().Name != nsname {
+			continue
+		}
+		// The glue records of the nameserver should point to the addresses handed out for it
+		if a, ok := rr.(*dns.A); ok && responseIP != nil && !a.A.Equal(responseIP) {
+			errs.add("ns.records", v, "the A record of the nameserver %s doesn't match ns_response_ip %s", ns.Nsname, ns.NSResponseIP)
+		}
+		if aaaa, ok := rr.(*dns.AAAA); ok && responseIP6 != nil && !aaaa.AAAA.Equal(responseIP6) {
+			errs.add("ns.records", v, "the AAAA record of the nameserver %s doesn't match ns_response_ip6 %s", ns.Nsname, ns.NSResponseIP6)
+		}
+	}
+	for _, d := range sortedKeys(ns.ZoneFiles) {
+		for _, filename := range ns.ZoneFiles[d] {
+			if _, err := ParseZoneFile(filename, strings.ToLower(dns.Fqdn(d))); err != nil {
+				errs.add("ns.zonefiles", filename, "%s", err)
+			}
+		}
+	}
+
+	for i, p := range ns.Profiles {
+		key := fmt.Sprintf("ns.profiles[%d]", i)
+		checkRegexes(errs, key+".regexes", p.Regexes)
+		for _, t := range append(append([]string{}, p.Types...), p.ReplaceTypes...) {
+			if _, ok := dns.StringToType[strings.ToUpper(t)]; !ok {
+				errs.add(key+".types", t, "unknown record type %q", t)
+			}
+		}
+		if ip := net.ParseIP(p.A); p.A != "" && (ip == nil || ip.To4() == nil) {
+			errs.add(key+".a", p.A, "%q is not an IPv4 address", p.A)
+		}
+		if ip := net.ParseIP(p.AAAA); p.AAAA != "" && (ip == nil || ip.To4() != nil) {
+			errs.add(key+".aaaa", p.AAAA, "%q is not an IPv6 address", p.AAAA)
+		}
+	}
+
+	for _, name := range sortedKeys(ns.TSIGKeys) {
+		secret := ns.TSIGKeys[name]
+		if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+			errs.add("ns.tsig_keys", name, "the secret of %s is not valid base64: %s", name, err)
+		}
+	}
+	if err := ns.AllowTransfer.IsValid(); err != nil {
+		errs.add("ns.allow_transfer", "", "%s", err)
+	}
+	if ns.EDNSMaxUDPSize < dns.MinMsgSize || ns.EDNSMaxUDPSize > dns.MaxMsgSize {
+		errs.add("ns.edns_max_udp_size", "", "%d is not between %d and %d", ns.EDNSMaxUDPSize, dns.MinMsgSize, dns.MaxMsgSize)
+	}
+	for _, addr := range ns.NotifySecondaries {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs.add("ns.notify_secondaries", addr, "%s", err)
+		}
+	}
+}
+
+func validateRateLimit(errs *ConfigErrors, conf CertainlyCFG) {
+	rl := conf.RateLimit
+	for _, v := range []struct {
+		key   string
+		value int
+	}{
+		{"ratelimit.responses_per_second", rl.ResponsesPerSecond},
+		{"ratelimit.all_per_second", rl.AllPerSecond},
+		{"ratelimit.window", rl.Window},
+		{"ratelimit.slip", rl.Slip},
+		{"ratelimit.log_interval", rl.LogInterval},
+	} {
+		if v.value < 0 {
+			errs.add(v.key, "", "can't be negative")
+		}
+	}
+	if rl.IPv4Prefix < 1 || rl.IPv4Prefix > 32 {
+		errs.add("ratelimit.ipv4_prefix", "", "%d is not between 1 and 32", rl.IPv4Prefix)
+	}
+	if rl.IPv6Prefix < 1 || rl.IPv6Prefix > 128 {
+		errs.add("ratelimit.ipv6_prefix", "", "%d is not between 1 and 128", rl.IPv6Prefix)
+	}
+	if err := rl.Exempt.IsValid(); err != nil {
+		errs.add("ratelimit.exempt", "", "%s", err)
+	}
+}
+
+func validateResolver(errs *ConfigErrors, conf CertainlyCFG) {
+	for _, upstream := range conf.Resolver.Upstreams {
+		if err := resolver.ValidUpstream(upstream); err != nil {
+			errs.add("resolver.upstreams", upstream, "%s", err)
+		}
+	}
+	if conf.Resolver.Timeout < 0 {
+		errs.add("resolver.timeout", "", "can't be negative")
+	}
+	if conf.Resolver.Retries < 0 {
+		errs.add("resolver.retries", "", "can't be negative")
+	}
+}
+
+func checkRegexes(errs *ConfigErrors, key string, regexes []string) {
+	for _, r := range regexes {
+		if _, err := regexp.Compile(r); err != nil {
+			errs.add(key, r, "invalid regex %q: %s", r, err)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map in order, so that the errors are reported in the same order on every run
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func inAnyDomain(name string, domains []string) bool {
+	for _, d := range domains {
+		if d != "" && dns.IsSubDomain(strings.ToLower(dns.Fqdn(d)), strings.ToLower(dns.Fqdn(name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// locate fills in the file and the line numbers of the errors. The line is looked up by the offending value
+// within the section of the setting, falling back to the line of the setting itself.
+func (errs ConfigErrors) locate(fname string) ConfigErrors {
+	f, err := os.Open(fname)
+	if err != nil {
+		return errs
+	}
+	defer f.Close()
+	type line struct {
+		section string
+		text    string
+	}
+	lines := []line{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "[") {
+			section = strings.Trim(strings.SplitN(text, "#", 2)[0], "[] \t")
+		}
+		lines = append(lines, line{section, text})
+	}
+	located := make(ConfigErrors, len(errs))
+	for i, e := range errs {
+		e.File = fname
+		// ns.profiles[1].regexes is found in the ns.profiles section with the name regexes
+		path := regexp.MustCompile(`\[\d+\]`).ReplaceAllString(e.Key, "")
+		sect, name := path, ""
+		if dot := strings.LastIndex(path, "."); dot > 0 {
+			sect, name = path[:dot], path[dot+1:]
+		}
+		inSection := func(l line) bool {
+			return l.section == sect || strings.HasPrefix(l.section, sect+".") || (sect == "" && l.section == "")
+		}
+		for n, l := range lines {
+			if strings.HasPrefix(l.text, "#") || !inSection(l) {
+				continue
+			}
+			if e.value != "" && strings.Contains(l.text, e.value) {
+				e.Line = n + 1
+				break
+			}
+			if e.Line == 0 && name != "" && (strings.HasPrefix(l.text, name+" ") || strings.HasPrefix(l.text, name+"=")) {
+				e.Line = n + 1
+				if e.value == "" {
+					break
+				}
+			}
+			if e.Line == 0 && name == "" && strings.HasPrefix(l.text, "[") {
+				e.Line = n + 1
+			}
+		}
+		located[i] = e
+	}
+	return located
+}
@@ -0,0 +1,34 @@
+package certainly
+
+import "testing"
+
+func TestValidateResolver(t *testing.T) {
+	tests := []struct {
+		upstreams []string
+		timeout   int
+		retries   int
+		keys      []string
+	}{
+		{[]string{"8.8.8.8:53", "udp://1.1.1.1", "tcp://8.8.8.8:53", "tls://dns.google", "https://dns.example/dns-query"}, 5, 1, nil},
+		{[]string{"htps://dns.example/dns-query"}, 5, 0, []string{"resolver.upstreams"}},
+		{[]string{"tls://"}, 5, 0, []string{"resolver.upstreams"}},
+		{[]string{"8.8.8.8:53"}, -1, -1, []string{"resolver.timeout", "resolver.retries"}},
+	}
+	for _, test := range tests {
+		conf := CertainlyCFG{}
+		conf.Resolver.Upstreams = test.upstreams
+		conf.Resolver.Timeout = test.timeout
+		conf.Resolver.Retries = test.retries
+		errs := ConfigErrors{}
+		validateResolver(&errs, conf)
+		if len(errs) != len(test.keys) {
+			t.Errorf("%v: got errors %v, want errors for %v", test.upstreams, errs, test.keys)
+			continue
+		}
+		for i, e := range errs {
+			if e.Key != test.keys[i] {
+				t.Errorf("%v: error %d is for %s, want %s", test.upstreams, i, e.Key, test.keys[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package certainly
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ParseZoneFile reads all the records from a zone file, following $INCLUDE directives relative to the file.
+// The parse errors contain the name of the file and the line number. Records outside of the origin are rejected, so
+// that a zone file can't make certainly authoritative for other domains.
+func ParseZoneFile(filename, origin string) ([]dns.RR, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := &lineCounter{r: bufio.NewReader(f)}
+	zp := dns.NewZoneParser(lines, origin, filename)
+	zp.SetIncludeAllowed(true)
+	rrs := []dns.RR{}
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(origin, rr.Header().Name) {
+			// The records of an included file are reported at the $INCLUDE line
+			return nil, fmt.Errorf("%s:%d: %s is outside of the zone %s", filename, lines.line(), rr.Header().Name, origin)
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// lineCounter counts the lines read by the zone parser, which reads the input byte by byte if it can. A record is
+// complete once the parser has read the newline ending it.
+type lineCounter struct {
+	r        *bufio.Reader
+	newlines int
+	last     byte
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for _, b := range p[:n] {
+		l.count(b)
+	}
+	return n, err
+}
+
+func (l *lineCounter) ReadByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.count(b)
+	}
+	return b, err
+}
+
+func (l *lineCounter) count(b byte) {
+	if b == '\n' {
+		l.newlines++
+	}
+	l.last = b
+}
+
+// line returns the line of the last byte read, the line ended by it for a newline
+func (l *lineCounter) line() int {
+	if l.last == '\n' {
+		return l.newlines
+	}
+	return l.newlines + 1
+}
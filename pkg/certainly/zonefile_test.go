@@ -0,0 +1,60 @@
+package certainly
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseZoneFileOutsideOrigin(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		fname := filepath.Join(dir, name)
+		if err := os.WriteFile(fname, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+	included := write("included.zone", "mail IN A 192.0.2.20\nwww.google.com. IN A 192.0.2.66\n")
+	soa := "$TTL 60\n" +
+		"@ IN SOA ns.example.com. admin.example.com. (\n" +
+		"  1 ; serial\n" +
+		"  3600 600 86400 60 )\n"
+	tests := []struct {
+		name     string
+		contents string
+		// line is the line of the error, 0 if the file is valid
+		line int
+	}{
+		{"valid.zone", soa + "www IN A 192.0.2.10\nWWW.Example.COM. IN AAAA 2001:db8::10\n", 0},
+		{"outside.zone", soa + "www IN A 192.0.2.10\nwww.google.com. IN A 192.0.2.66\n", 6},
+		{"suffix.zone", "notexample.com. 60 IN A 192.0.2.66\n", 1},
+		{"noeol.zone", soa + "www.google.com. IN A 192.0.2.66", 5},
+		{"include.zone", soa + "$INCLUDE " + included + "\nwww IN A 192.0.2.10\n", 5},
+	}
+	for _, test := range tests {
+		fname := write(test.name, test.contents)
+		rrs, err := ParseZoneFile(fname, "example.com.")
+		if test.line == 0 {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+			for _, rr := range rrs {
+				if name := rr.Header().Name; name != strings.ToLower(name) {
+					t.Errorf("%s: record name %s isn't lowercased", test.name, name)
+				}
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: parsed %d records, want an error", test.name, len(rrs))
+			continue
+		}
+		prefix := fname + ":" + strconv.Itoa(test.line) + ": "
+		if !strings.HasPrefix(err.Error(), prefix) || !strings.Contains(err.Error(), "outside of the zone example.com.") {
+			t.Errorf("%s: error %q, want it to start with %q", test.name, err, prefix)
+		}
+	}
+}
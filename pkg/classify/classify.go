@@ -0,0 +1,229 @@
+package classify
+
+import (
+	_ "embed"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// bundledPrefixes is the offline list of the public resolver and scanner networks
+//
+//go:embed prefixes.txt
+var bundledPrefixes string
+
+// sweepWindow is the period the distinct names queried by a source are counted over
+const sweepWindow = time.Minute
+
+// maxSources bounds the number of sources tracked for the sweep detection
+const maxSources = 100000
+
+// Result is the classification of a single query
+type Result struct {
+	// Class is one of the certainly.Class* values, empty if the classification is disabled
+	Class string
+	// Tags are the label of the matching prefix list entry followed by the traits seen in the query
+	Tags []string
+}
+
+// Classifier tags the sources of the DNS queries as public resolvers, ISP resolvers, scanners or end hosts, using the
+// prefix lists, the traits of the query and the number of distinct names queried by the source
+type Classifier struct {
+	Logger *zap.SugaredLogger
+	// mu guards everything below, the settings are replaced when the configuration is reloaded
+	mu       sync.Mutex
+	settings settings
+	sources  map[string]*source
+	expired  time.Time
+}
+
+type settings struct {
+	enabled bool
+	// prefixes has the entries of the local prefix list first, so that they override the bundled ones
+	prefixes       []certainly.PrefixEntry
+	sweepThreshold int
+}
+
+// source counts the distinct names queried by an address in the current sweep window
+type source struct {
+	start time.Time
+	names map[string]struct{}
+	// sweeping is set if the source went over the threshold in the previous window
+	sweeping bool
+}
+
+// New creates the classifier. A prefix list that can't be read is logged, and only the bundled list is used.
+func New(config *certainly.CertainlyCFG, logger *zap.SugaredLogger) *Classifier {
+	c := &Classifier{Logger: logger, sources: make(map[string]*source)}
+	s, err := newSettings(config)
+	if err != nil {
+		logger.Errorw("Could not read the prefix list",
+			"error", err)
+	}
+	c.settings = s
+	return c
+}
+
+func newSettings(config *certainly.CertainlyCFG) (settings, error) {
+	s := settings{enabled: config.Classify.Enabled, sweepThreshold: config.Classify.SweepThreshold}
+	var err error
+	if config.Classify.PrefixList != "" {
+		s.prefixes, err = certainly.ReadPrefixFile(config.Classify.PrefixList)
+	}
+	bundled, bundledErr := certainly.ParsePrefixList(strings.NewReader(bundledPrefixes), "prefixes.txt")
+	if bundledErr != nil {
+		// The bundled list is checked before a release, so this is a programming error
+		panic(bundledErr)
+	}
+	s.prefixes = append(s.prefixes, bundled...)
+	return s, err
+}
+
+// Reload switches to the settings of the new configuration, rejecting it if the prefix list can't be read
+func (c *Classifier) Reload(config *certainly.CertainlyCFG) error {
+	s, err := newSettings(config)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = s
+	return nil
+}
+
+// Classify classifies the source of the query r
+func (c *Classifier) Classify(remote net.Addr, r *dns.Msg) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.settings.enabled {
+		return Result{}
+	}
+	res := Result{Class: certainly.ClassUnknown, Tags: []string{}}
+	ip := util.AddrIP(remote)
+	if ip != nil {
+		if entry := c.settings.lookup(ip); entry != nil {
+			res.Class = entry.Class
+			res.Tags = append(res.Tags, entry.Label)
+		}
+	}
+	traits := queryTraits(r)
+	res.Tags = append(res.Tags, traits...)
+	if res.Class != certainly.ClassUnknown {
+		return res
+	}
+	// The public resolvers query lots of names on behalf of their clients, so only the unlisted sources are tracked
+	if ip != nil && len(r.Question) > 0 && c.sweeping(ip.String(), r.Question[0].Name) {
+		res.Tags = append(res.Tags, "sweep")
+	}
+	resolver := hasTag(traits, "iterative") || hasTag(traits, "0x20") || hasTag(traits, "ecs")
+	switch {
+	case hasTag(traits, "probe"):
+		res.Class = certainly.ClassScanner
+	case resolver:
+		// A resolver forwarding the queries of its users may well look like a sweep, so it's not a scanner
+		res.Class = certainly.ClassISPResolver
+	case hasTag(res.Tags, "sweep"):
+		res.Class = certainly.ClassScanner
+	case r.RecursionDesired:
+		res.Class = certainly.ClassEndHost
+	}
+	return res
+}
+
+func (s settings) lookup(ip net.IP) *certainly.PrefixEntry {
+	for i := range s.prefixes {
+		if s.prefixes[i].Network.Contains(ip) {
+			return &s.prefixes[i]
+		}
+	}
+	return nil
+}
+
+// queryTraits returns the tags for the traits of the query telling apart the resolvers, the stub resolvers of the
+// end hosts and the scanners
+func queryTraits(r *dns.Msg) []string {
+	tags := []string{}
+	if !r.RecursionDesired {
+		// Recursive resolvers query the authoritative servers without recursion, stub resolvers ask for it
+		tags = append(tags, "iterative")
+	}
+	if len(r.Question) > 0 {
+		q := r.Question[0]
+		if mixedCase(q.Name) {
+			// Resolvers randomize the case of the name to protect against spoofing, draft-vixie-dnsext-dns0x20
+			tags = append(tags, "0x20")
+		}
+		if q.Qtype == dns.TypeANY || q.Qclass == dns.ClassCHAOS || strings.EqualFold(q.Name, "version.bind.") {
+			tags = append(tags, "probe")
+		}
+	}
+	opt := r.IsEdns0()
+	if opt == nil {
+		return append(tags, "no-edns")
+	}
+	if opt.Do() {
+		tags = append(tags, "do")
+	}
+	for _, o := range opt.Option {
+		switch o.(type) {
+		case *dns.EDNS0_SUBNET:
+			tags = append(tags, "ecs")
+		case *dns.EDNS0_COOKIE:
+			tags = append(tags, "cookie")
+		}
+	}
+	return tags
+}
+
+// mixedCase returns true if the name has both upper and lower case letters
+func mixedCase(name string) bool {
+	return strings.ToLower(name) != name && strings.ToUpper(name) != name
+}
+
+// sweeping records the name queried by the source, and returns true if the source has queried more distinct names
+// than the threshold in the current or the previous sweep window
+func (c *Classifier) sweeping(addr, name string) bool {
+	now := time.Now()
+	if now.Sub(c.expired) > sweepWindow {
+		c.expired = now
+		for key, s := range c.sources {
+			if now.Sub(s.start) > 2*sweepWindow {
+				delete(c.sources, key)
+			}
+		}
+	}
+	s, ok := c.sources[addr]
+	if !ok {
+		if len(c.sources) >= maxSources {
+			return false
+		}
+		s = &source{start: now, names: make(map[string]struct{})}
+		c.sources[addr] = s
+	}
+	if now.Sub(s.start) > sweepWindow {
+		s.sweeping = len(s.names) > c.settings.sweepThreshold && now.Sub(s.start) <= 2*sweepWindow
+		s.start = now
+		s.names = make(map[string]struct{})
+	}
+	// Stop counting at the threshold, there's no need to remember the whole sweep
+	if len(s.names) <= c.settings.sweepThreshold {
+		s.names[strings.ToLower(name)] = struct{}{}
+	}
+	return s.sweeping || len(s.names) > c.settings.sweepThreshold
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
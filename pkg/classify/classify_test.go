@@ -0,0 +1,158 @@
+package classify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// query describes the test query, every query is recursive and has EDNS unless set otherwise
+type query struct {
+	name      string
+	qtype     uint16
+	qclass    uint16
+	iterative bool
+	noEDNS    bool
+	do        bool
+	ecs       bool
+	cookie    bool
+}
+
+func (q query) msg() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(q.name, q.qtype)
+	if q.qclass != 0 {
+		m.Question[0].Qclass = q.qclass
+	}
+	m.RecursionDesired = !q.iterative
+	if q.noEDNS {
+		return m
+	}
+	m.SetEdns0(1232, q.do)
+	opt := m.IsEdns0()
+	if q.ecs {
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("198.51.100.0")})
+	}
+	if q.cookie {
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+	}
+	return m
+}
+
+func newTestClassifier(t *testing.T, prefixList string, threshold int) *Classifier {
+	t.Helper()
+	config := &certainly.CertainlyCFG{}
+	config.Classify.Enabled = true
+	config.Classify.SweepThreshold = threshold
+	if prefixList != "" {
+		config.Classify.PrefixList = filepath.Join(t.TempDir(), "prefixes.txt")
+		if err := os.WriteFile(config.Classify.PrefixList, []byte(prefixList), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(config, zap.NewNop().Sugar())
+}
+
+func TestClassify(t *testing.T) {
+	c := newTestClassifier(t, "isp-resolver example-isp 203.0.113.0/24\npublic-resolver override 9.9.9.9/32\n", 50)
+	tests := []struct {
+		ip    string
+		q     query
+		class string
+		tags  string
+	}{
+		{"8.8.8.8", query{name: "www.coogle.com.", qtype: dns.TypeA, iterative: true, do: true}, certainly.ClassPublicResolver, "google,iterative,do"},
+		{"2a00:1450::1", query{name: "www.coogle.com.", qtype: dns.TypeAAAA, iterative: true}, certainly.ClassPublicResolver, "google,iterative"},
+		// The listed class wins over the traits
+		{"162.142.125.10", query{name: "coogle.com.", qtype: dns.TypeA}, certainly.ClassScanner, "censys"},
+		{"203.0.113.7", query{name: "coogle.com.", qtype: dns.TypeA}, certainly.ClassISPResolver, "example-isp"},
+		// The local list is looked up before the bundled one
+		{"9.9.9.9", query{name: "coogle.com.", qtype: dns.TypeA, iterative: true}, certainly.ClassPublicResolver, "override,iterative"},
+		{"9.9.9.10", query{name: "coogle.com.", qtype: dns.TypeA, iterative: true}, certainly.ClassPublicResolver, "quad9,iterative"},
+		// Unlisted sources are classified by the traits of the query
+		{"192.0.2.1", query{name: "www.coogle.com.", qtype: dns.TypeA}, certainly.ClassEndHost, ""},
+		{"192.0.2.1", query{name: "www.coogle.com.", qtype: dns.TypeA, noEDNS: true}, certainly.ClassEndHost, "no-edns"},
+		{"192.0.2.1", query{name: "www.coogle.com.", qtype: dns.TypeA, cookie: true}, certainly.ClassEndHost, "cookie"},
+		{"192.0.2.2", query{name: "www.coogle.com.", qtype: dns.TypeA, iterative: true}, certainly.ClassISPResolver, "iterative"},
+		{"192.0.2.3", query{name: "wWw.CoOgle.com.", qtype: dns.TypeA}, certainly.ClassISPResolver, "0x20"},
+		{"192.0.2.4", query{name: "www.coogle.com.", qtype: dns.TypeA, ecs: true}, certainly.ClassISPResolver, "ecs"},
+		{"192.0.2.5", query{name: "coogle.com.", qtype: dns.TypeANY}, certainly.ClassScanner, "probe"},
+		{"192.0.2.5", query{name: "version.bind.", qtype: dns.TypeTXT, qclass: dns.ClassCHAOS, noEDNS: true}, certainly.ClassScanner, "probe,no-edns"},
+		// The probe traits win over the resolver traits
+		{"192.0.2.6", query{name: "coogle.com.", qtype: dns.TypeANY, iterative: true}, certainly.ClassScanner, "iterative,probe"},
+	}
+	for _, test := range tests {
+		res := c.Classify(&net.UDPAddr{IP: net.ParseIP(test.ip), Port: 53}, test.q.msg())
+		if res.Class != test.class || strings.Join(res.Tags, ",") != test.tags {
+			t.Errorf("%s %s: %s [%s], want %s [%s]", test.ip, test.q.name, res.Class, strings.Join(res.Tags, ","), test.class, test.tags)
+		}
+	}
+}
+
+func TestClassifySweep(t *testing.T) {
+	c := newTestClassifier(t, "", 3)
+	classify := func(ip string, q query) Result {
+		return c.Classify(&net.TCPAddr{IP: net.ParseIP(ip), Port: 53}, q.msg())
+	}
+	// Repeating the same names, in any case, doesn't count as a sweep
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("name%d.coogle.com.", i%3)
+		if i%2 == 1 {
+			name = strings.ToUpper(name)
+		}
+		if res := classify("192.0.2.1", query{name: name, qtype: dns.TypeA}); hasTag(res.Tags, "sweep") {
+			t.Fatalf("query %d for %s was tagged as a sweep", i, name)
+		}
+	}
+	res := classify("192.0.2.1", query{name: "name3.coogle.com.", qtype: dns.TypeA})
+	if res.Class != certainly.ClassScanner || !hasTag(res.Tags, "sweep") {
+		t.Errorf("fourth distinct name: %s %v, want a sweeping scanner", res.Class, res.Tags)
+	}
+	// A resolver forwarding the queries of its users isn't a scanner even if it sweeps
+	for i := 0; i < 5; i++ {
+		res = classify("192.0.2.2", query{name: fmt.Sprintf("name%d.coogle.com.", i), qtype: dns.TypeA, iterative: true})
+	}
+	if res.Class != certainly.ClassISPResolver || !hasTag(res.Tags, "sweep") {
+		t.Errorf("sweeping resolver: %s %v, want an ISP resolver tagged as a sweep", res.Class, res.Tags)
+	}
+	// The listed sources aren't tracked at all
+	for i := 0; i < 5; i++ {
+		res = classify("8.8.8.8", query{name: fmt.Sprintf("name%d.coogle.com.", i), qtype: dns.TypeA})
+	}
+	if res.Class != certainly.ClassPublicResolver || hasTag(res.Tags, "sweep") {
+		t.Errorf("public resolver: %s %v", res.Class, res.Tags)
+	}
+	if _, ok := c.sources["8.8.8.8"]; ok {
+		t.Error("a listed source is tracked for the sweeps")
+	}
+}
+
+func TestClassifyDisabled(t *testing.T) {
+	c := New(&certainly.CertainlyCFG{}, zap.NewNop().Sugar())
+	res := c.Classify(&net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}, query{name: "coogle.com.", qtype: dns.TypeA}.msg())
+	if res.Class != "" || len(res.Tags) != 0 {
+		t.Errorf("disabled classifier returned %s %v", res.Class, res.Tags)
+	}
+}
+
+func TestReloadKeepsSettingsOnError(t *testing.T) {
+	c := newTestClassifier(t, "scanner local 192.0.2.0/24\n", 50)
+	config := &certainly.CertainlyCFG{}
+	config.Classify.Enabled = true
+	config.Classify.PrefixList = filepath.Join(t.TempDir(), "missing.txt")
+	if err := c.Reload(config); err == nil {
+		t.Fatal("Reload succeeded with a missing prefix list")
+	}
+	res := c.Classify(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, query{name: "coogle.com.", qtype: dns.TypeA}.msg())
+	if res.Class != certainly.ClassScanner {
+		t.Errorf("class %s after a failed reload, want the local list to still apply", res.Class)
+	}
+}
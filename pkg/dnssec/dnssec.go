@@ -0,0 +1,107 @@
+package dnssec
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Signatures are valid from an hour in the past to account for clock skew
+	signatureInception  = time.Hour
+	signatureValidity   = 7 * 24 * time.Hour
+	defaultKeyAlgorithm = dns.ECDSAP256SHA256
+)
+
+// Key is a combined signing key, used for signing both the DNSKEY RRset and the rest of the zone
+type Key struct {
+	DNSKEY *dns.DNSKEY
+	Signer crypto.Signer
+}
+
+// LoadOrCreate reads the key of the zone from the key directory, generating a new key if one doesn't exist yet
+func LoadOrCreate(dir, zone string) (*Key, error) {
+	zone = dns.Fqdn(strings.ToLower(zone))
+	base := filepath.Join(dir, strings.TrimSuffix(zone, "."))
+	if _, err := os.Stat(base + ".key"); err == nil {
+		return load(base)
+	}
+	return create(base, zone)
+}
+
+func load(base string) (*Key, error) {
+	data, err := os.ReadFile(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	rr, err := dns.NewRR(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse DNSKEY from %s.key: %s", base, err)
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s.key does not contain a DNSKEY record", base)
+	}
+	f, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	privkey, err := dnskey.ReadPrivateKey(f, base+".private")
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privkey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type in %s.private", base)
+	}
+	return &Key{DNSKEY: dnskey, Signer: signer}, nil
+}
+
+func create(base, zone string) (*Key, error) {
+	dnskey := new(dns.DNSKEY)
+	dnskey.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600}
+	dnskey.Flags = dns.ZONE | dns.SEP
+	dnskey.Protocol = 3
+	dnskey.Algorithm = defaultKeyAlgorithm
+	privkey, err := dnskey.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(base), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(base+".private", []byte(dnskey.PrivateKeyString(privkey)), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(base+".key", []byte(dnskey.String()+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	return &Key{DNSKEY: dnskey, Signer: privkey.(crypto.Signer)}, nil
+}
+
+// Sign creates the RRSIG record for the RRset
+func (k *Key) Sign(rrset []dns.RR) (*dns.RRSIG, error) {
+	now := time.Now()
+	sig := new(dns.RRSIG)
+	sig.Hdr = dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl}
+	sig.KeyTag = k.DNSKEY.KeyTag()
+	sig.SignerName = k.DNSKEY.Hdr.Name
+	sig.Algorithm = k.DNSKEY.Algorithm
+	sig.Inception = uint32(now.Add(-signatureInception).Unix())
+	sig.Expiration = uint32(now.Add(signatureValidity).Unix())
+	if err := sig.Sign(k.Signer, rrset); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// DS returns the SHA-256 DS record to be given to the registrar of the zone
+func (k *Key) DS() *dns.DS {
+	return k.DNSKEY.ToDS(dns.SHA256)
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+var eventBucket = []byte("events")
+
+// BoltStore is an EventSink that stores the events in an embedded bbolt database, keyed by their timestamp
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the event database for writing
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// OpenBoltStore opens an existing event database in read-only mode for querying
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if !certainly.FileIsAccessible(path) {
+		return nil, fmt.Errorf("event database %s not found", path)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("could not open event database %s, is certainly still running? %s", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Store writes the event to the database. The concurrent calls are committed together in a single transaction, so
+// that a flood of events isn't limited to one disk sync per event.
+func (b *BoltStore) Store(event certainly.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(eventKey(event.Time, seq), data)
+	})
+}
+
+// Query calls fn for every stored event between from and to in chronological order.
+// Zero value for either of the times leaves that end of the range open.
+func (b *BoltStore) Query(from, to time.Time, fn func(certainly.Event) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		var k, v []byte
+		if from.IsZero() {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(eventKey(from, 0))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !to.IsZero() && int64(binary.BigEndian.Uint64(k[:8])) > to.UnixNano() {
+				return nil
+			}
+			var event certainly.Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// eventKey sorts the keys chronologically, the sequence number makes sure that events with the same timestamp don't collide
+func eventKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
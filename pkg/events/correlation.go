@@ -0,0 +1,149 @@
+package events
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+type sessionEntry struct {
+	session string
+	seen    time.Time
+}
+
+// Correlator links the events of a single client across protocols into a session.
+//
+// The links are made by the UUIDs certainly hands out (DNS CNAME targets and HTTP CERTAINLY_HASH), the requested
+// hostname together with the address of the client or resolver, and the address of the client for the non-DNS
+// protocols. The hostname alone isn't enough, as every client of a popular flipped domain would end up in the same
+// session.
+type Correlator struct {
+	mutex         sync.Mutex
+	window        time.Duration
+	defaultDomain string
+	tokens        map[string]*sessionEntry
+	hosts         map[string]*sessionEntry
+	clients       map[string]*sessionEntry
+	lastPrune     time.Time
+}
+
+func NewCorrelator(defaultDomain string, window time.Duration) *Correlator {
+	return &Correlator{
+		window:        window,
+		defaultDomain: strings.ToLower(strings.TrimSuffix(defaultDomain, ".")),
+		tokens:        make(map[string]*sessionEntry),
+		hosts:         make(map[string]*sessionEntry),
+		clients:       make(map[string]*sessionEntry),
+		lastPrune:     time.Now(),
+	}
+}
+
+// Correlate sets the session ID of the event, creating a new session if the event can't be linked to an existing one
+func (c *Correlator) Correlate(event *certainly.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := event.Time
+	c.prune(now)
+
+	host := strings.ToLower(strings.TrimSuffix(event.ServerName, "."))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	client := remoteIP(event.RemoteAddr)
+	tokens := c.eventTokens(event, host)
+
+	session := ""
+	for _, token := range tokens {
+		if s := c.lookup(c.tokens, token, now); s != "" {
+			session = s
+			break
+		}
+	}
+	if session == "" && host != "" {
+		session = c.lookup(c.hosts, hostKey(client, host), now)
+	}
+	// DNS questions come from the resolvers, which are shared by a lot of clients
+	if session == "" && event.Protocol != "dns" && client != "" {
+		session = c.lookup(c.clients, client, now)
+	}
+	if session == "" {
+		session = uuid.New().String()
+	}
+
+	event.SessionID = session
+	if event.CorrelationID != "" {
+		c.tokens[strings.ToLower(event.CorrelationID)] = &sessionEntry{session, now}
+	}
+	for _, token := range tokens {
+		c.tokens[token] = &sessionEntry{session, now}
+	}
+	if host != "" {
+		c.hosts[hostKey(client, host)] = &sessionEntry{session, now}
+	}
+	if event.Protocol != "dns" && client != "" {
+		c.clients[client] = &sessionEntry{session, now}
+	}
+}
+
+// eventTokens finds the UUIDs handed out by certainly from the event, either as a subdomain of the default domain
+// or from the HTTP request uri (eg. callbacks from the injected templates)
+func (c *Correlator) eventTokens(event *certainly.Event, host string) []string {
+	tokens := []string{}
+	if c.defaultDomain != "" && util.HasApexDomain(host, c.defaultDomain) {
+		label := strings.SplitN(host, ".", 2)[0]
+		if _, err := uuid.Parse(label); err == nil {
+			tokens = append(tokens, label)
+		}
+	}
+	if uri, ok := event.Data["uri"]; ok {
+		for _, token := range uuidRegexp.FindAllString(uri, -1) {
+			tokens = append(tokens, strings.ToLower(token))
+		}
+	}
+	return tokens
+}
+
+func (c *Correlator) lookup(entries map[string]*sessionEntry, key string, now time.Time) string {
+	entry, ok := entries[key]
+	if !ok || now.Sub(entry.seen) > c.window {
+		return ""
+	}
+	return entry.session
+}
+
+// prune removes the expired entries once per window
+func (c *Correlator) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < c.window {
+		return
+	}
+	for _, entries := range []map[string]*sessionEntry{c.tokens, c.hosts, c.clients} {
+		for key, entry := range entries {
+			if now.Sub(entry.seen) > c.window {
+				delete(entries, key)
+			}
+		}
+	}
+	c.lastPrune = now
+}
+
+// hostKey is the key of the hosts map, linking the hostname only for the same client or resolver
+func hostKey(client, host string) string {
+	return client + "|" + host
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
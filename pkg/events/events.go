@@ -0,0 +1,85 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+type Events struct {
+	Sinks      []certainly.EventSink
+	Correlator *Correlator
+	Config     *certainly.CertainlyCFG
+	Logger     *zap.SugaredLogger
+	// mu guards Config and flips, which are replaced when the configuration is reloaded
+	mu sync.RWMutex
+	// flips caches the flip analysis of the rewrite rules
+	flips map[string]certainly.Flip
+}
+
+func Initialize(config *certainly.CertainlyCFG, logger *zap.SugaredLogger) *Events {
+	events := &Events{Config: config, Logger: logger, flips: analyzeFlips(config.Rewrites)}
+	events.Correlator = NewCorrelator(config.NS.DefaultDomain, time.Duration(config.Events.SessionWindow)*time.Second)
+	events.Sinks = make([]certainly.EventSink, 0)
+	if config.Events.Store {
+		store, err := NewBoltStore(config.Events.Path)
+		if err != nil {
+			logger.Errorw("Failed to initialize event store",
+				"path", config.Events.Path,
+				"error", err)
+		} else {
+			events.Sinks = append(events.Sinks, store)
+		}
+	}
+	return events
+}
+
+// Reload switches to the rewrites of the new configuration for analyzing the flips. The event store and the session
+// window are kept until a restart.
+func (e *Events) Reload(config *certainly.CertainlyCFG) error {
+	flips := analyzeFlips(config.Rewrites)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Config = config
+	e.flips = flips
+	return nil
+}
+
+// Record fills in the event metadata and the flip, links it to a session and hands the event over to all the
+// configured sinks
+func (e *Events) Record(event *certainly.Event) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Flip == nil && event.ServerName != "" {
+		e.mu.RLock()
+		analyzeFlip(event, e.Config.Rewrites, e.flips)
+		e.mu.RUnlock()
+	}
+	e.Correlator.Correlate(event)
+	for _, sink := range e.Sinks {
+		if err := sink.Store(*event); err != nil {
+			e.Logger.Errorw("Failed to store event",
+				"protocol", event.Protocol,
+				"id", event.ID,
+				"error", err)
+		}
+	}
+}
+
+// Close closes all the event sinks
+func (e *Events) Close() {
+	for _, sink := range e.Sinks {
+		if err := sink.Close(); err != nil {
+			e.Logger.Errorw("Failed to close event sink",
+				"error", err)
+		}
+	}
+}
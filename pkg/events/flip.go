@@ -0,0 +1,44 @@
+package events
+
+import (
+	"net"
+	"strings"
+
+	"github.com/happycakefriends/certainly/pkg/bitflip"
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// analyzeFlips returns the flip of every rewrite rule keyed by the lowercased source domain. The analysis regenerates
+// all the candidates of the target, so it's done once when the configuration is loaded instead of for every event.
+func analyzeFlips(rewrites map[string]string) map[string]certainly.Flip {
+	flips := make(map[string]certainly.Flip, len(rewrites))
+	for from, to := range rewrites {
+		from = strings.ToLower(from)
+		flip := certainly.Flip{Domain: from, Target: to}
+		if c, ok := bitflip.Analyze(from, to); ok {
+			flip.Category = c.Category
+			flip.Position = c.Position
+			flip.Bit = c.Bit
+			flip.Label, flip.LabelOffset = bitflip.Label(c.Target, c.Position)
+		}
+		flips[from] = flip
+	}
+	return flips
+}
+
+// analyzeFlip sets the flip of the event if its server name is under one of the rewrite sources
+func analyzeFlip(event *certainly.Event, rewrites map[string]string, flips map[string]certainly.Flip) {
+	name := event.ServerName
+	// The HTTP Host header may have the port
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	from, _, ok := util.RewriteFor(strings.ToLower(name), rewrites)
+	if !ok {
+		return
+	}
+	if flip, ok := flips[from]; ok {
+		event.Flip = &flip
+	}
+}
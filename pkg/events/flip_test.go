@@ -0,0 +1,92 @@
+package events
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+func newTestEvents(rewrites map[string]string) *Events {
+	config := &certainly.CertainlyCFG{}
+	config.NS.DefaultDomain = "mom.tld"
+	config.Rewrites = rewrites
+	return Initialize(config, zap.NewNop().Sugar())
+}
+
+func TestRecordFlip(t *testing.T) {
+	e := newTestEvents(map[string]string{
+		"Coogle.com":  "google.com",
+		"gogle.com":   "google.com",
+		"google.co":   "google.com",
+		"example.org": "google.com",
+	})
+	tests := []struct {
+		serverName string
+		flip       *certainly.Flip
+	}{
+		// The server name is matched case insensitively, with or without the port
+		{"WWW.Coogle.COM:443", &certainly.Flip{Domain: "coogle.com", Target: "google.com", Category: "bitflip", Position: 0, Bit: 2, Label: 2, LabelOffset: 0}},
+		{"coogle.com.", &certainly.Flip{Domain: "coogle.com", Target: "google.com", Category: "bitflip", Position: 0, Bit: 2, Label: 2, LabelOffset: 0}},
+		{"mail.gogle.com", &certainly.Flip{Domain: "gogle.com", Target: "google.com", Category: "omission", Position: 1, Label: 2, LabelOffset: 1}},
+		{"google.co:8443", &certainly.Flip{Domain: "google.co", Target: "google.com", Category: "tld-swap", Position: 7, Label: 1, LabelOffset: 0}},
+		// A rewrite source that isn't a single change of the target only has the domains
+		{"example.org", &certainly.Flip{Domain: "example.org", Target: "google.com"}},
+		{"notcoogle.com", nil},
+		{"unrelated.example", nil},
+	}
+	for _, test := range tests {
+		event := &certainly.Event{Protocol: "http", ServerName: test.serverName}
+		e.Record(event)
+		switch {
+		case test.flip == nil && event.Flip != nil:
+			t.Errorf("%s: flip %+v, want none", test.serverName, *event.Flip)
+		case test.flip != nil && event.Flip == nil:
+			t.Errorf("%s: no flip, want %+v", test.serverName, *test.flip)
+		case test.flip != nil && *event.Flip != *test.flip:
+			t.Errorf("%s: flip %+v, want %+v", test.serverName, *event.Flip, *test.flip)
+		}
+	}
+}
+
+func TestRecordKeepsFlip(t *testing.T) {
+	e := newTestEvents(map[string]string{"coogle.com": "google.com"})
+	flip := &certainly.Flip{Domain: "set.example", Target: "google.com"}
+	event := &certainly.Event{Protocol: "http", ServerName: "coogle.com", Flip: flip}
+	e.Record(event)
+	if event.Flip != flip {
+		t.Errorf("flip %+v, want the one set by the caller", *event.Flip)
+	}
+}
+
+func TestFlipIsCopy(t *testing.T) {
+	e := newTestEvents(map[string]string{"coogle.com": "google.com"})
+	first := &certainly.Event{Protocol: "http", ServerName: "coogle.com"}
+	e.Record(first)
+	first.Flip.Category = "modified"
+	second := &certainly.Event{Protocol: "http", ServerName: "coogle.com"}
+	e.Record(second)
+	if second.Flip == nil || second.Flip.Category != "bitflip" {
+		t.Errorf("flip %+v, modifying an event changed the cached flip", second.Flip)
+	}
+}
+
+func TestReloadFlips(t *testing.T) {
+	e := newTestEvents(map[string]string{"coogle.com": "google.com"})
+	config := &certainly.CertainlyCFG{}
+	config.Rewrites = map[string]string{"woogle.com": "google.com"}
+	if err := e.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	old := &certainly.Event{Protocol: "http", ServerName: "coogle.com"}
+	e.Record(old)
+	if old.Flip != nil {
+		t.Errorf("flip %+v for a rewrite removed by the reload", *old.Flip)
+	}
+	added := &certainly.Event{Protocol: "http", ServerName: "www.woogle.com"}
+	e.Record(added)
+	if added.Flip == nil || added.Flip.Domain != "woogle.com" || added.Flip.Category != "bitflip" {
+		t.Errorf("flip %+v, want the bitflip of the reloaded rewrite", added.Flip)
+	}
+}
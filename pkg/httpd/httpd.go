@@ -4,16 +4,21 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
+	"github.com/happycakefriends/certainly/pkg/lifecycle"
 	"github.com/happycakefriends/certainly/pkg/notification"
+	"github.com/happycakefriends/certainly/pkg/rules"
 	"github.com/happycakefriends/certainly/pkg/util"
 	"go.uber.org/zap"
 )
@@ -21,42 +26,115 @@ import (
 type HTTPD struct {
 	Config       *certainly.CertainlyCFG
 	Logger       *zap.SugaredLogger
-	errChan      chan error
+	TLSConfig    *tls.Config
 	Notification *notification.Notifications
+	Events       *events.Events
+	// mu guards Config and the rules compiled from it, which are replaced when the configuration is reloaded
+	mu               sync.RWMutex
+	injections       *rules.Set
+	injectionFilters *rules.Set
 }
 
-func InitAndStart(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) *HTTPD {
+func Initialize(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, events *events.Events) *HTTPD {
 	h := &HTTPD{
 		Config:       config,
 		Logger:       logger,
-		errChan:      errChan,
+		TLSConfig:    tlsconfig,
 		Notification: notification,
+		Events:       events,
+	}
+	var err error
+	h.injections, h.injectionFilters, err = compileInjections(config)
+	if err != nil {
+		logger.Errorw("Could not compile the injection rules",
+			"error", err)
 	}
-	go h.startHTTP(config, logger, notification, errChan)
-	go h.startHTTPS(tlsconfig, config, logger, notification, errChan)
 	return h
 }
 
-func (h *HTTPD) ShouldInjectTemplate(req *http.Request) (bool, string) {
-	for _, filter := range h.Config.HTTPD.InjectionFilters {
-		match, err := regexp.MatchString(filter, req.RequestURI)
-		if err == nil && match {
-			return false, ""
-		}
+// Listeners returns the plaintext HTTP and HTTPS listeners
+func (h *HTTPD) Listeners() []certainly.Listener {
+	stderrorlog, err := zap.NewStdLogAt(h.Logger.Desugar(), zap.ErrorLevel)
+	if err != nil {
+		h.Logger.Errorw("Could not create the HTTP error logger",
+			"error", err)
 	}
-	for rule, templatef := range h.Config.HTTPDInjections {
-		match, err := regexp.MatchString(rule, req.RequestURI)
-		if err == nil && match {
-			return true, templatef
+	httpSrv := h.httpServer(h.Config, h.Logger, h.Notification, stderrorlog)
+	httpsSrv := h.httpsServer(h.TLSConfig, h.Config, h.Logger, h.Notification, stderrorlog)
+	return []certainly.Listener{
+		lifecycle.NewHTTPListener("http "+httpSrv.Addr, httpSrv),
+		lifecycle.NewHTTPListener("https "+httpsSrv.Addr, httpsSrv),
+	}
+}
+
+// Reload switches the rewrites and the injection templates and filters to the ones in the new configuration
+func (h *HTTPD) Reload(config *certainly.CertainlyCFG) error {
+	injections, filters, err := compileInjections(config)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Config = config
+	h.injections, h.injectionFilters = injections, filters
+	return nil
+}
+
+// compileInjections compiles the injection rules and filters. The rules of the injection_rules list are evaluated by
+// priority and then in the listed order, followed by the rules of the httpd_injection_templates table ordered by regex.
+func compileInjections(config *certainly.CertainlyCFG) (*rules.Set, *rules.Set, error) {
+	injectionRules := []rules.Rule{}
+	for i, r := range config.HTTPD.InjectionRules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("httpd.injection_rules[%d]", i)
 		}
+		injectionRules = append(injectionRules, rules.Rule{Name: name, Pattern: r.Regex, Priority: r.Priority, Value: r.Template})
+	}
+	patterns := make([]string, 0, len(config.HTTPDInjections))
+	for pattern := range config.HTTPDInjections {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		injectionRules = append(injectionRules, rules.Rule{Pattern: pattern, Value: config.HTTPDInjections[pattern]})
+	}
+	injections, err := rules.Compile(injectionRules)
+	if err != nil {
+		return nil, nil, err
 	}
-	return false, ""
+	filters, err := rules.Compile(rules.FromPatterns("httpd.injection_filters", config.HTTPD.InjectionFilters))
+	if err != nil {
+		return nil, nil, err
+	}
+	return injections, filters, nil
+}
+
+func (h *HTTPD) config() *certainly.CertainlyCFG {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Config
+}
+
+// ShouldInjectTemplate returns true and the matching injection rule if a template should be injected to the response.
+// The injection filters are checked first, a matching filter is returned with false.
+func (h *HTTPD) ShouldInjectTemplate(req *http.Request) (bool, *rules.Rule) {
+	h.mu.RLock()
+	injections, filters := h.injections, h.injectionFilters
+	h.mu.RUnlock()
+	if filter := filters.Match(req.RequestURI); filter != nil {
+		return false, filter
+	}
+	if rule := injections.Match(req.RequestURI); rule != nil {
+		return true, rule
+	}
+	return false, nil
 }
 
 func (h *HTTPD) MakeProxyRequest(req *http.Request, proto string) (*http.Response, error) {
 	url := req.URL
 	url.Scheme = proto
-	url.Host = util.ReplaceApex(req.Host, h.Config.Rewrites)
+	url.Host = util.ReplaceApex(req.Host, h.config().Rewrites)
 	newReq, err := http.NewRequest(req.Method, req.URL.String(), req.Body)
 	if err != nil {
 		return nil, err
@@ -64,7 +142,7 @@ func (h *HTTPD) MakeProxyRequest(req *http.Request, proto string) (*http.Respons
 	for header, values := range req.Header {
 		for _, value := range values {
 			if strings.ToLower(header) == "host" {
-				value = util.ReplaceApex(value, h.Config.Rewrites)
+				value = util.ReplaceApex(value, h.config().Rewrites)
 			}
 			newReq.Header.Add(header, value)
 		}
@@ -86,7 +164,7 @@ func (h *HTTPD) copyHeaders(from http.Header, to http.Header) {
 }
 
 func (h *HTTPD) injectTemplate(templateFile, data, hash string) string {
-	templateFilePath := filepath.Join(h.Config.HTTPD.InjectionTemplateFilepath, templateFile)
+	templateFilePath := filepath.Join(h.config().HTTPD.InjectionTemplateFilepath, templateFile)
 	templateData, err := os.ReadFile(templateFilePath)
 	if err != nil {
 		h.Logger.Errorw("Could not read template file", "error", err)
@@ -96,12 +174,33 @@ func (h *HTTPD) injectTemplate(templateFile, data, hash string) string {
 	return strings.ReplaceAll(data, "CERTAINLY_HASH", hash)
 }
 
-func (h *HTTPD) startHTTPS(tlsconfig *tls.Config, config *certainly.CertainlyCFG, sugar *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) {
-	stderrorlog, err := zap.NewStdLogAt(sugar.Desugar(), zap.ErrorLevel)
-	if err != nil {
-		errChan <- err
-		return
+// recordRequest stores the inbound HTTP(S) request as an event with the injection rule or filter that matched it,
+// and returns the session ID
+func (h *HTTPD) recordRequest(r *http.Request, hash string, dump []byte, inject bool, rule *rules.Rule) string {
+	event := certainly.NewEvent("http", r.RemoteAddr)
+	event.ServerName = r.Host
+	if r.TLS != nil {
+		event.TLS = true
+		if r.TLS.ServerName != "" {
+			event.ServerName = r.TLS.ServerName
+		}
 	}
+	event.CorrelationID = hash
+	event.Data["host"] = r.Host
+	event.Data["method"] = r.Method
+	event.Data["uri"] = r.RequestURI
+	event.Data["userAgent"] = r.UserAgent()
+	event.Data["request"] = string(dump)
+	if rule != nil && inject {
+		event.Data["injectionRule"] = rule.Name
+	} else if rule != nil {
+		event.Data["injectionFilter"] = rule.Name
+	}
+	h.Events.Record(event)
+	return event.SessionID
+}
+
+func (h *HTTPD) httpsServer(tlsconfig *tls.Config, config *certainly.CertainlyCFG, sugar *zap.SugaredLogger, notification *notification.Notifications, stderrorlog *log.Logger) *http.Server {
 
 	tlsconfig.NextProtos = append([]string{"http/1.1", "h2", "http/1.0"}, tlsconfig.NextProtos...)
 
@@ -111,16 +210,19 @@ func (h *HTTPD) startHTTPS(tlsconfig *tls.Config, config *certainly.CertainlyCFG
 		if err != nil {
 			sugar.Error(err)
 		}
+		shouldInject, rule := h.ShouldInjectTemplate(r)
+		session := h.recordRequest(r, uuid, res, shouldInject, rule)
 		notification.Notify("http", fmt.Sprintf(`
 Inbound HTTPS request %s from: %s
+Session: %s
 
-%s`, uuid, r.RemoteAddr, string(res)))
+%s`, uuid, r.RemoteAddr, session, string(res)))
 		sugar.Infow(
 			"Inbound HTTPS request",
 			"request", string(res),
 			"remoteAddr", r.RemoteAddr,
-			"uuid", uuid)
-		shouldInject, templateFile := h.ShouldInjectTemplate(r)
+			"uuid", uuid,
+			"session", session)
 		if shouldInject {
 			proxyResp, err := h.MakeProxyRequest(r, "https")
 			if err != nil {
@@ -134,7 +236,11 @@ Inbound HTTPS request %s from: %s
 				}
 				h.copyHeaders(proxyResp.Header, w.Header())
 				w.WriteHeader(proxyResp.StatusCode)
-				w.Write([]byte(h.injectTemplate(templateFile, string(bodyBytes), uuid)))
+				sugar.Infow("Injecting template",
+					"template", rule.Value,
+					"rule", rule.Name,
+					"uuid", uuid)
+				w.Write([]byte(h.injectTemplate(rule.Value, string(bodyBytes), uuid)))
 				return
 			}
 		} else {
@@ -142,7 +248,7 @@ Inbound HTTPS request %s from: %s
 				w.WriteHeader(http.StatusNoContent)
 				return
 			}
-			for source, target := range config.Rewrites {
+			for source, target := range h.config().Rewrites {
 				if strings.Contains(r.Host, source) {
 					target := "https://" + strings.Replace(r.Host, source, target, 1) + r.URL.Path
 					if len(r.URL.RawQuery) > 0 {
@@ -156,21 +262,15 @@ Inbound HTTPS request %s from: %s
 		fmt.Fprintf(w, "Excellent choice, sir!")
 	})
 
-	srv := &http.Server{
+	return &http.Server{
 		Addr:      ":" + config.HTTPD.HTTPSPort,
 		Handler:   handler,
 		TLSConfig: tlsconfig,
 		ErrorLog:  stderrorlog,
 	}
-	errChan <- srv.ListenAndServeTLS("", "")
 }
 
-func (h *HTTPD) startHTTP(config *certainly.CertainlyCFG, sugar *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) {
-	stderrorlog, err := zap.NewStdLogAt(sugar.Desugar(), zap.ErrorLevel)
-	if err != nil {
-		errChan <- err
-		return
-	}
+func (h *HTTPD) httpServer(config *certainly.CertainlyCFG, sugar *zap.SugaredLogger, notification *notification.Notifications, stderrorlog *log.Logger) *http.Server {
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		uuid := uuid.New().String()
@@ -178,18 +278,21 @@ func (h *HTTPD) startHTTP(config *certainly.CertainlyCFG, sugar *zap.SugaredLogg
 		if err != nil {
 			sugar.Error(err)
 		}
+		shouldInject, rule := h.ShouldInjectTemplate(r)
+		session := h.recordRequest(r, uuid, res, shouldInject, rule)
 		notification.Notify("http", fmt.Sprintf(`
 Inbound plaintext HTTP request from: %s
+Session: %s
 
-%s`, r.RemoteAddr, string(res)))
+%s`, r.RemoteAddr, session, string(res)))
 
 		sugar.Infow(
 			"Inbound HTTP request",
 			"request", string(res),
 			"remoteAddr", r.RemoteAddr,
-			"uuid", uuid)
+			"uuid", uuid,
+			"session", session)
 
-		shouldInject, templateFile := h.ShouldInjectTemplate(r)
 		if shouldInject {
 			proxyResp, err := h.MakeProxyRequest(r, "http")
 			if err != nil {
@@ -203,7 +306,11 @@ Inbound plaintext HTTP request from: %s
 				}
 				h.copyHeaders(proxyResp.Header, w.Header())
 				w.WriteHeader(proxyResp.StatusCode)
-				w.Write([]byte(h.injectTemplate(templateFile, string(bodyBytes), uuid)))
+				sugar.Infow("Injecting template",
+					"template", rule.Value,
+					"rule", rule.Name,
+					"uuid", uuid)
+				w.Write([]byte(h.injectTemplate(rule.Value, string(bodyBytes), uuid)))
 				return
 			}
 		} else {
@@ -211,7 +318,7 @@ Inbound plaintext HTTP request from: %s
 				w.WriteHeader(http.StatusNoContent)
 				return
 			}
-			for source, target := range config.Rewrites {
+			for source, target := range h.config().Rewrites {
 				if strings.Contains(r.Host, source) {
 					target := "http://" + strings.Replace(r.Host, source, target, 1) + r.URL.Path
 					if len(r.URL.RawQuery) > 0 {
@@ -225,10 +332,9 @@ Inbound plaintext HTTP request from: %s
 		fmt.Fprintf(w, "Excellent choice, sir!")
 	})
 
-	srv := &http.Server{
+	return &http.Server{
 		Addr:     ":" + config.HTTPD.HTTPPort,
 		Handler:  handler,
 		ErrorLog: stderrorlog,
 	}
-	errChan <- srv.ListenAndServe()
 }
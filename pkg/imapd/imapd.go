@@ -1,16 +1,19 @@
 package imapd
 
 import (
+	"context"
 	"crypto/tls"
-	"fmt"
 	"io"
 	"net"
+	"strconv"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
 	"github.com/happycakefriends/certainly/pkg/imapd/imapmemserver"
+	"github.com/happycakefriends/certainly/pkg/lifecycle"
 	"github.com/happycakefriends/certainly/pkg/notification"
 	"go.uber.org/zap"
 )
@@ -20,37 +23,52 @@ type Imapd struct {
 	TLSConfig    *tls.Config
 	Logger       *zap.SugaredLogger
 	Notification *notification.Notifications
-	errChan      chan error
+	Events       *events.Events
 }
 
-func Initialize(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) *Imapd {
-	return &Imapd{config, tlsconfig, logger, notification, errChan}
+func Initialize(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, events *events.Events) *Imapd {
+	return &Imapd{config, tlsconfig, logger, notification, events}
 }
 
-func (i *Imapd) Start() {
-	go i.ListenAndServe(143, false)
-	go i.ListenAndServe(993, true)
+// Listeners returns the IMAP and IMAPS listeners
+func (i *Imapd) Listeners() []certainly.Listener {
+	return []certainly.Listener{
+		&imapListener{i: i, name: "imap", port: 143},
+		&imapListener{i: i, name: "imaps", port: 993, imaps: true},
+	}
 }
 
-func (i *Imapd) ListenAndServe(port int, imaps bool) {
-	var ln net.Listener
-	var err error
-	if imaps {
-		ln, err = tls.Listen("tcp", fmt.Sprintf("%s:%d", i.Config.General.IP, port), i.TLSConfig)
-	} else {
-		ln, err = net.Listen("tcp", fmt.Sprintf("%s:%d", i.Config.General.IP, port))
-	}
+// imapListener is a single IMAP port. The connections are tracked to let the clients finish on shutdown,
+// closing the server drops them right away.
+type imapListener struct {
+	i      *Imapd
+	name   string
+	port   int
+	imaps  bool
+	server *imapserver.Server
+	ln     *lifecycle.TrackedListener
+}
+
+func (l *imapListener) addr() string {
+	return net.JoinHostPort(l.i.Config.General.IP, strconv.Itoa(l.port))
+}
 
+func (l *imapListener) Name() string {
+	return l.name + " " + l.addr()
+}
+
+func (l *imapListener) Listen() error {
+	ln, err := net.Listen("tcp", l.addr())
 	if err != nil {
-		i.errChan <- err
-		return
+		return err
 	}
+	l.ln = lifecycle.NewTrackedListener(ln)
 
-	memServer := imapmemserver.New(i.Logger, i.Notification)
+	memServer := imapmemserver.New(l.i.Logger, l.i.Notification, l.i.Events)
 
 	options := &imapserver.Options{
 		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
-			return memServer.NewSession(), nil, nil
+			return memServer.NewSession(conn.NetConn()), nil, nil
 		},
 		Caps: imap.CapSet{
 			imap.CapIMAP4rev1: {},
@@ -60,13 +78,24 @@ func (i *Imapd) ListenAndServe(port int, imaps bool) {
 		DebugWriter:  io.Discard,
 	}
 
-	if imaps {
-		options.TLSConfig = i.TLSConfig
+	if l.imaps {
+		options.TLSConfig = l.i.TLSConfig
 	}
 
-	server := imapserver.New(options)
+	l.server = imapserver.New(options)
+	return nil
+}
 
-	if err := server.Serve(ln); err != nil {
-		i.errChan <- err
+func (l *imapListener) Serve() error {
+	var ln net.Listener = l.ln
+	if l.imaps {
+		ln = tls.NewListener(ln, l.i.TLSConfig)
 	}
+	return l.server.Serve(ln)
+}
+
+func (l *imapListener) Shutdown(ctx context.Context) error {
+	err := l.ln.Drain(ctx)
+	l.server.Close()
+	return err
 }
@@ -2,12 +2,16 @@
 package imapmemserver
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"sync"
 
 	"github.com/emersion/go-imap/v2/imapserver"
 	"go.uber.org/zap"
 
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
 	"github.com/happycakefriends/certainly/pkg/notification"
 )
 
@@ -19,20 +23,29 @@ type Server struct {
 	users        map[string]*User
 	Logger       *zap.SugaredLogger
 	Notification *notification.Notifications
+	Events       *events.Events
 }
 
 // New creates a new server.
-func New(logger *zap.SugaredLogger, notification *notification.Notifications) *Server {
+func New(logger *zap.SugaredLogger, notification *notification.Notifications, events *events.Events) *Server {
 	return &Server{
 		users:        make(map[string]*User),
 		Logger:       logger,
 		Notification: notification,
+		Events:       events,
 	}
 }
 
-// NewSession creates a new IMAP session.
-func (s *Server) NewSession() imapserver.Session {
-	return &serverSession{server: s}
+// NewSession creates a new IMAP session for a client connection.
+func (s *Server) NewSession(conn net.Conn) imapserver.Session {
+	sess := &serverSession{server: s, remoteAddr: conn.RemoteAddr().String()}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// The handshake would otherwise only happen when writing the greeting
+		_ = tlsConn.Handshake()
+		sess.tls = true
+		sess.serverName = tlsConn.ConnectionState().ServerName
+	}
+	return sess
 }
 
 func (s *Server) user(username string) *User {
@@ -52,19 +65,34 @@ type serverSession struct {
 	*UserSession // may be nil
 
 	server *Server // immutable
+
+	remoteAddr string
+	serverName string
+	tls        bool
 }
 
 var _ imapserver.Session = (*serverSession)(nil)
 
 func (sess *serverSession) Login(username, password string) error {
+	event := certainly.NewEvent("imap", sess.remoteAddr)
+	event.ServerName = sess.serverName
+	event.TLS = sess.tls
+	event.Data["type"] = "login"
+	event.Data["username"] = username
+	event.Data["password"] = password
+	sess.server.Events.Record(event)
+
 	sess.server.Notification.Notify("imap", fmt.Sprintf(`
-IMAP login
+IMAP login from: %s
+Session: %s
 Username: %s
 Password: %s
-`, username, password))
+`, sess.remoteAddr, event.SessionID, username, password))
 
 	sess.server.Logger.Infow("Received imap auth credentials",
+		"remoteAddr", sess.remoteAddr,
 		"username", username,
-		"password", password)
+		"password", password,
+		"session", event.SessionID)
 	return imapserver.ErrAuthFailed
 }
@@ -0,0 +1,73 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// TrackedListener keeps track of the connections accepted from the listener, for the servers that can't wait
+// for their open connections on their own
+type TrackedListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+type trackedConn struct {
+	net.Conn
+	l    *TrackedListener
+	once sync.Once
+}
+
+func NewTrackedListener(ln net.Listener) *TrackedListener {
+	return &TrackedListener{Listener: ln, conns: make(map[*trackedConn]struct{})}
+}
+
+func (l *TrackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: conn, l: l}
+	l.mu.Lock()
+	l.conns[tc] = struct{}{}
+	l.mu.Unlock()
+	return tc, nil
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.l.mu.Lock()
+		delete(c.l.conns, c)
+		c.l.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// Drain closes the listener and waits for the open connections to be closed. The connections still open
+// when the context is done are closed forcibly.
+func (l *TrackedListener) Drain(ctx context.Context) error {
+	l.Listener.Close()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		l.mu.Lock()
+		open := len(l.conns)
+		l.mu.Unlock()
+		if open == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			l.mu.Lock()
+			for c := range l.conns {
+				c.Conn.Close()
+			}
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// HTTPListener runs an http.Server as a managed listener, serving TLS if the server has a TLS config
+type HTTPListener struct {
+	name string
+	srv  *http.Server
+	ln   net.Listener
+}
+
+func NewHTTPListener(name string, srv *http.Server) *HTTPListener {
+	return &HTTPListener{name: name, srv: srv}
+}
+
+func (h *HTTPListener) Name() string {
+	return h.name
+}
+
+func (h *HTTPListener) Listen() error {
+	ln, err := net.Listen("tcp", h.srv.Addr)
+	if err != nil {
+		return err
+	}
+	h.ln = ln
+	return nil
+}
+
+func (h *HTTPListener) Serve() error {
+	if h.srv.TLSConfig != nil {
+		return h.srv.ServeTLS(h.ln, "", "")
+	}
+	return h.srv.Serve(h.ln)
+}
+
+func (h *HTTPListener) Shutdown(ctx context.Context) error {
+	err := h.srv.Shutdown(ctx)
+	// Serve may not have taken over the socket yet
+	h.ln.Close()
+	return err
+}
@@ -0,0 +1,219 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// Listener states reported in Health
+const (
+	StateListening = "listening"
+	StateFailed    = "failed"
+	StateStopped   = "stopped"
+)
+
+// minBackoff and maxBackoff bound the delay between the restart attempts of a failed listener
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Health is the current state of a single listener
+type Health struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+}
+
+// Manager runs the listeners of all the servers. A listener that fails is restarted with an increasing delay
+// without affecting the others, and on shutdown all of them are drained in parallel.
+type Manager struct {
+	Logger    *zap.SugaredLogger
+	listeners []*managedListener
+	// mu guards the listener health and the stopping flag, and is held while binding so that Shutdown sees every bound listener
+	mu       sync.Mutex
+	stopping bool
+	stop     chan struct{}
+	running  sync.WaitGroup
+}
+
+type managedListener struct {
+	listener certainly.Listener
+	health   Health
+}
+
+func New(logger *zap.SugaredLogger) *Manager {
+	return &Manager{Logger: logger, stop: make(chan struct{})}
+}
+
+// Start binds the listeners and serves them in the background. It returns once every listener has been bound
+// or has failed to bind, the failed ones are retried in the background.
+func (m *Manager) Start(listeners ...certainly.Listener) {
+	for _, l := range listeners {
+		ml := &managedListener{listener: l, health: Health{Name: l.Name()}}
+		m.mu.Lock()
+		m.listeners = append(m.listeners, ml)
+		m.mu.Unlock()
+		ok, err := m.listen(ml)
+		if !ok {
+			m.setHealth(ml, StateStopped, nil)
+			continue
+		}
+		m.running.Add(1)
+		go m.run(ml, err)
+	}
+}
+
+// run serves the listener until shutdown, restarting it when it fails. err is the result of the first bind.
+func (m *Manager) run(ml *managedListener, err error) {
+	defer m.running.Done()
+	backoff := minBackoff
+	for {
+		if err == nil {
+			err = ml.listener.Serve()
+			if m.isStopping() {
+				m.setHealth(ml, StateStopped, nil)
+				return
+			}
+			if err == nil {
+				err = errors.New("listener stopped unexpectedly")
+			}
+			m.setHealth(ml, StateFailed, err)
+			m.Logger.Errorw("Listener failed",
+				"listener", ml.listener.Name(),
+				"error", err)
+			// The listener was up, so start over with the shortest delay
+			backoff = minBackoff
+		}
+		m.Logger.Infow("Restarting listener",
+			"listener", ml.listener.Name(),
+			"delay", backoff.String())
+		select {
+		case <-time.After(backoff):
+		case <-m.stop:
+			m.setHealth(ml, StateStopped, nil)
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		m.mu.Lock()
+		ml.health.Restarts++
+		m.mu.Unlock()
+		var ok bool
+		if ok, err = m.listen(ml); !ok {
+			m.setHealth(ml, StateStopped, nil)
+			return
+		}
+	}
+}
+
+// listen binds the listener and updates its health. It returns false without binding if the manager is shutting down.
+func (m *Manager) listen(ml *managedListener) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopping {
+		return false, nil
+	}
+	err := ml.listener.Listen()
+	ml.health.Since = time.Now()
+	if err != nil {
+		ml.health.State, ml.health.Error = StateFailed, err.Error()
+		m.Logger.Errorw("Could not start listener",
+			"listener", ml.health.Name,
+			"error", err)
+		return true, err
+	}
+	ml.health.State, ml.health.Error = StateListening, ""
+	m.Logger.Infow("Listening",
+		"listener", ml.health.Name)
+	return true, nil
+}
+
+func (m *Manager) setHealth(ml *managedListener, state string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ml.health.State = state
+	ml.health.Error = ""
+	if err != nil {
+		ml.health.Error = err.Error()
+	}
+	ml.health.Since = time.Now()
+}
+
+func (m *Manager) isStopping() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopping
+}
+
+// Health returns the state of every listener
+func (m *Manager) Health() []Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := make([]Health, 0, len(m.listeners))
+	for _, ml := range m.listeners {
+		health = append(health, ml.health)
+	}
+	return health
+}
+
+// Shutdown stops all the listeners, waiting for their open connections to finish until the context is done
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.stopping {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopping = true
+	close(m.stop)
+	listening := []*managedListener{}
+	for _, ml := range m.listeners {
+		if ml.health.State == StateListening {
+			listening = append(listening, ml)
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	errMu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, ml := range listening {
+		ml := ml
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ml.listener.Shutdown(ctx); err != nil {
+				errMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", ml.listener.Name(), err))
+				errMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// A listener that ran out of time has already been reported
+		if len(errs) == 0 {
+			errs = append(errs, fmt.Errorf("waiting for the listeners to stop: %w", ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package nameserver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/dnssec"
+)
+
+// loadKeys loads or generates the DNSSEC keys for all the managed zones and adds the DNSKEY records to the zones
+func (n *Nameserver) loadKeys() {
+	if !n.Config.NS.DNSSEC {
+		return
+	}
+	for _, apex := range n.OwnDomains {
+		n.loadKey(apex)
+	}
+}
+
+func (n *Nameserver) loadKey(apex string) {
+	key, err := dnssec.LoadOrCreate(n.Config.NS.DNSSECKeyDir, apex)
+	if err != nil {
+		n.Logger.Errorw("Could not load DNSSEC key, zone will not be signed",
+			"domain", apex,
+			"error", err)
+		return
+	}
+	n.keys[apex] = key
+	n.appendRR(key.DNSKEY)
+	n.Logger.Infow("Loaded DNSSEC key",
+		"domain", apex,
+		"keytag", key.DNSKEY.KeyTag())
+}
+
+// signResponse adds the denial of existence records and signs the answer and authority sections of the response
+func (n *Nameserver) signResponse(m *dns.Msg) {
+	if len(m.Question) == 0 || !m.Authoritative {
+		return
+	}
+	q := m.Question[0]
+	apex := n.zoneApex(q.Name)
+	if _, ok := n.keys[apex]; !ok {
+		return
+	}
+	if len(m.Answer) == 0 && (m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError) {
+		// Deny the existence with a minimal NSEC record that covers only the questioned name ("black lies").
+		// This works for the synthesized names without having to enumerate the zone, but turns NXDOMAIN to NODATA.
+		if nsec := n.denialNSEC(q, apex, m.Rcode == dns.RcodeNameError); nsec != nil {
+			m.Ns = append(m.Ns, nsec)
+			m.Rcode = dns.RcodeSuccess
+		}
+	}
+	m.Answer = n.signRRsets(m.Answer)
+	m.Ns = n.signRRsets(m.Ns)
+}
+
+func (n *Nameserver) denialNSEC(q dns.Question, apex string, nxdomain bool) dns.RR {
+	soa := n.negativeSOA(apex)
+	if soa == nil {
+		return nil
+	}
+	name := strings.ToLower(q.Name)
+	nsec := new(dns.NSEC)
+	nsec.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: soa.Header().Ttl}
+	nsec.NextDomain = "\\000." + name
+	types := map[uint16]bool{dns.TypeRRSIG: true, dns.TypeNSEC: true}
+	if !nxdomain {
+		for _, t := range n.typesAt(name) {
+			types[t] = true
+		}
+	}
+	delete(types, q.Qtype)
+	for t := range types {
+		nsec.TypeBitMap = append(nsec.TypeBitMap, t)
+	}
+	sort.Slice(nsec.TypeBitMap, func(i, j int) bool { return nsec.TypeBitMap[i] < nsec.TypeBitMap[j] })
+	return nsec
+}
+
+// typesAt returns the record types that exist for the name, both static and synthesized
+func (n *Nameserver) typesAt(name string) []uint16 {
+	types := []uint16{}
+	for _, rr := range n.Domains[name].Records {
+		types = append(types, rr.Header().Rrtype)
+	}
+	if n.answeringForDomain(name) {
+		for t := range n.profileFor(name).types {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// signRRsets appends the signatures after each RRset in the section that belongs to a signed zone
+func (n *Nameserver) signRRsets(section []dns.RR) []dns.RR {
+	signed := []dns.RR{}
+	for _, rrset := range groupRRsets(section) {
+		signed = append(signed, rrset...)
+		rrtype := rrset[0].Header().Rrtype
+		if rrtype == dns.TypeRRSIG || rrtype == dns.TypeOPT {
+			continue
+		}
+		key, ok := n.keys[n.zoneApex(rrset[0].Header().Name)]
+		if !ok {
+			continue
+		}
+		sig, err := key.Sign(rrset)
+		if err != nil {
+			n.Logger.Errorw("Could not sign RRset",
+				"domain", rrset[0].Header().Name,
+				"type", dns.TypeToString[rrtype],
+				"error", err)
+			continue
+		}
+		signed = append(signed, sig)
+	}
+	return signed
+}
+
+// rrsetKey identifies an RRset by its owner name and type
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// groupRRsets groups the records by their name and type, keeping the order of the first appearance.
+// The TTLs within an RRset are set to the lowest one as required for signing.
+func groupRRsets(section []dns.RR) [][]dns.RR {
+	index := make(map[rrsetKey]int)
+	rrsets := [][]dns.RR{}
+	for _, rr := range section {
+		key := rrsetKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	for _, rrset := range rrsets {
+		ttl := rrset[0].Header().Ttl
+		for _, rr := range rrset {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		for i, rr := range rrset {
+			if rr.Header().Ttl != ttl {
+				// Don't modify the static records
+				rrset[i] = dns.Copy(rr)
+				rrset[i].Header().Ttl = ttl
+			}
+		}
+	}
+	return rrsets
+}
@@ -0,0 +1,120 @@
+package nameserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/classify"
+)
+
+// clientOptions holds the EDNS options and the classification of the source of a query, recorded with the events of
+// its questions
+type clientOptions struct {
+	// udpSize is the buffer size advertised by the client
+	udpSize uint16
+	// subnet is the EDNS Client Subnet sent by a resolver on behalf of the client, in CIDR notation
+	subnet string
+	// cookie is the client cookie in hex
+	cookie string
+	// client is the classification of the source
+	client classify.Result
+}
+
+// newCookieSecret returns the secret for the server cookies, generated on every start as the cookies don't need to
+// survive restarts
+func newCookieSecret() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+// handleEDNS sets the OPT record of the response to m following RFC 6891, echoing the Client Subnet and cookie
+// options of the query. It returns the options of the query, or false if the response code was set to an error.
+func (n *Nameserver) handleEDNS(opt *dns.OPT, m *dns.Msg, remote net.Addr, config *certainly.CertainlyCFG) (clientOptions, bool) {
+	maxSize := uint16(config.NS.EDNSMaxUDPSize)
+	m.SetEdns0(maxSize, opt.Do())
+	resp := m.IsEdns0()
+	options := clientOptions{udpSize: opt.UDPSize()}
+	if opt.Version() != 0 {
+		// Only EDNS0 is standardized
+		m.Rcode = dns.RcodeBadVers
+		resp.SetDo(false)
+		return options, false
+	}
+	for _, o := range opt.Option {
+		switch o := o.(type) {
+		case *dns.EDNS0_SUBNET:
+			if !validSubnet(o) {
+				m.Rcode = dns.RcodeFormatError
+				return options, false
+			}
+			options.subnet = fmt.Sprintf("%s/%d", o.Address.String(), o.SourceNetmask)
+			// The answers don't depend on the client network, so the scope is 0 as defined in RFC 7871 section 7.2.1
+			resp.Option = append(resp.Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        o.Family,
+				SourceNetmask: o.SourceNetmask,
+				SourceScope:   0,
+				Address:       o.Address,
+			})
+		case *dns.EDNS0_COOKIE:
+			// A client cookie is 8 bytes and a server cookie from 8 to 32 bytes, RFC 7873 section 5.2.2
+			if l := len(o.Cookie); l != 16 && (l < 32 || l > 80) {
+				m.Rcode = dns.RcodeFormatError
+				return options, false
+			}
+			options.cookie = o.Cookie[:16]
+			resp.Option = append(resp.Option, &dns.EDNS0_COOKIE{
+				Code:   dns.EDNS0COOKIE,
+				Cookie: options.cookie + n.serverCookie(options.cookie, remote),
+			})
+		}
+	}
+	return options, true
+}
+
+func validSubnet(o *dns.EDNS0_SUBNET) bool {
+	switch o.Family {
+	case 1:
+		return o.SourceNetmask <= net.IPv4len*8
+	case 2:
+		return o.SourceNetmask <= net.IPv6len*8
+	}
+	return false
+}
+
+// serverCookie returns the server cookie for the client cookie and address in hex
+func (n *Nameserver) serverCookie(clientCookie string, remote net.Addr) string {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		host = remote.String()
+	}
+	mac := hmac.New(sha256.New, n.cookieSecret)
+	mac.Write([]byte(clientCookie))
+	mac.Write([]byte(host))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// truncate fits the response to the buffer size of the client. Over UDP the size is the buffer size advertised with
+// EDNS capped to edns_max_udp_size, or 512 bytes without EDNS. The TC flag is set if records had to be left out,
+// so that the client retries over TCP.
+func (n *Nameserver) truncate(m *dns.Msg, opt *dns.OPT, remote net.Addr, config *certainly.CertainlyCFG) {
+	size := dns.MaxMsgSize
+	if _, udp := remote.(*net.UDPAddr); udp {
+		size = dns.MinMsgSize
+		if opt != nil && opt.UDPSize() > dns.MinMsgSize {
+			size = int(opt.UDPSize())
+			if size > config.NS.EDNSMaxUDPSize {
+				size = config.NS.EDNSMaxUDPSize
+			}
+		}
+	}
+	m.Truncate(size)
+}
@@ -3,66 +3,137 @@ package nameserver
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/ratelimit"
 	"github.com/happycakefriends/certainly/pkg/util"
 	"github.com/miekg/dns"
 )
 
 func (n *Nameserver) handleRequest(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Opcode == dns.OpcodeUpdate {
+		n.handleUpdate(w, r)
+		return
+	}
+	if r.Opcode == dns.OpcodeQuery && len(r.Question) == 1 && (r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR) {
+		n.handleTransfer(w, r)
+		return
+	}
 	m := new(dns.Msg)
 	m.SetReply(r)
-	// handle edns0
+	if r.Opcode != dns.OpcodeQuery {
+		// certainly is only a primary server, so NOTIFY messages are not expected either
+		m.MsgHdr.Rcode = dns.RcodeNotImplemented
+		_ = w.WriteMsg(m)
+		return
+	}
+	// Only UDP can be used for reflection, the source of a TCP query can't be spoofed
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && n.Limiter != nil && len(r.Question) > 0 {
+		switch n.Limiter.Check(w.RemoteAddr(), r.Question[0].Name, r.Question[0].Qtype) {
+		case ratelimit.Drop:
+			return
+		case ratelimit.Slip:
+			m.Truncated = true
+			_ = w.WriteMsg(m)
+			return
+		}
+	}
+	n.mu.RLock()
+	config := n.Config
+	n.mu.RUnlock()
 	opt := r.IsEdns0()
+	options, ok := clientOptions{}, true
 	if opt != nil {
-		if opt.Version() != 0 {
-			// Only EDNS0 is standardized
-			m.MsgHdr.Rcode = dns.RcodeBadVers
-			m.SetEdns0(512, false)
-		} else {
-			// We can safely do this as we know that we're not setting other OPT RRs within certainly.
-			m.SetEdns0(512, false)
-			if r.Opcode == dns.OpcodeQuery {
-				n.readQuery(m, w.RemoteAddr().String())
-			}
-		}
-	} else {
-		if r.Opcode == dns.OpcodeQuery {
-			n.readQuery(m, w.RemoteAddr().String())
-		}
+		options, ok = n.handleEDNS(opt, m, w.RemoteAddr(), config)
 	}
+	answers := []*questionAnswer{}
+	if ok {
+		options.client = n.classifier.Classify(w.RemoteAddr(), r)
+		answers = n.readQuery(m, w.RemoteAddr(), config, opt != nil && opt.Do() && config.NS.DNSSEC)
+	}
+	n.truncate(m, opt, w.RemoteAddr(), config)
 	_ = w.WriteMsg(m)
+	// The events are recorded after the response is sent, as storing them goes to the disk
+	for _, a := range answers {
+		n.recordAnswer(a, w.RemoteAddr(), options)
+	}
+}
+
+// questionAnswer is the answer to a single question, recorded as an event once the response has been sent
+type questionAnswer struct {
+	q             dns.Question
+	records       []dns.RR
+	rcode         int
+	authoritative bool
+	event         *certainly.Event
 }
 
-func (n *Nameserver) readQuery(m *dns.Msg, remoteAddr string) {
+// readQuery answers the questions of the query to m. The lock is only held while reading the records, and not during
+// the upstream queries of the mirrored zones.
+func (n *Nameserver) readQuery(m *dns.Msg, remote net.Addr, config *certainly.CertainlyCFG, sign bool) []*questionAnswer {
+	answers := []*questionAnswer{}
 	var authoritative = false
-	for _, que := range m.Question {
-		if rr, rc, auth, err := n.answer(que, remoteAddr); err == nil {
-			if auth {
-				authoritative = auth
-			}
-			m.MsgHdr.Rcode = rc
-			m.Answer = append(m.Answer, rr...)
+	for i, que := range m.Question {
+		a := n.answer(que, remote, config)
+		answers = append(answers, a)
+		if a.authoritative {
+			authoritative = true
+		}
+		// In practice there's only a single question, the response code is set by the first one
+		if i == 0 {
+			m.MsgHdr.Rcode = a.rcode
 		}
+		m.Answer = append(m.Answer, a.records...)
 	}
 	m.MsgHdr.Authoritative = authoritative
-	if authoritative {
-		if m.MsgHdr.Rcode == dns.RcodeNameError {
-			m.Ns = append(m.Ns, n.SOA)
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if authoritative && len(m.Question) > 0 {
+		n.addAuthority(m, m.Question[0])
+	}
+	if sign {
+		n.signResponse(m)
+	}
+	return answers
+}
+
+// addAuthority adds the SOA record for negative answers as defined in RFC 2308, and the NS records and
+// their addresses of the zone for positive answers
+func (n *Nameserver) addAuthority(m *dns.Msg, q dns.Question) {
+	apex := n.zoneApex(q.Name)
+	if apex == "" {
+		return
+	}
+	if len(m.Answer) == 0 {
+		if m.MsgHdr.Rcode == dns.RcodeSuccess || m.MsgHdr.Rcode == dns.RcodeNameError {
+			if soa := n.negativeSOA(apex); soa != nil {
+				m.Ns = append(m.Ns, soa)
+			}
 		}
+		return
 	}
+	if q.Qtype == dns.TypeNS && strings.EqualFold(q.Name, apex) {
+		// The NS records are already in the answer section
+		m.Extra = append(m.Extra, n.glue(m.Answer)...)
+		return
+	}
+	nsRecords := n.zoneNS(apex)
+	m.Ns = append(m.Ns, nsRecords...)
+	m.Extra = append(m.Extra, n.glue(nsRecords)...)
 }
 
-func (n *Nameserver) answer(q dns.Question, remoteAddr string) ([]dns.RR, int, bool, error) {
-	var rcode int
-	var authoritative = n.isAuthoritative(q)
-	rcode = dns.RcodeSuccess
+// answer answers a single question. The records and the profile are read under the lock, and the synthesized and
+// mirrored records are made without it, using the configuration the query started with.
+func (n *Nameserver) answer(q dns.Question, remote net.Addr, config *certainly.CertainlyCFG) *questionAnswer {
+	a := &questionAnswer{q: q, rcode: dns.RcodeSuccess, event: certainly.NewEvent("dns", remote.String())}
+	n.mu.RLock()
+	a.authoritative = n.isAuthoritative(q)
 	r, err := n.getRecord(q)
-
-	if err != nil {
-		rcode = dns.RcodeNameError
-	}
+	// The name exists if it has any records at all, even if none of them are of the questioned type
+	nameExists := err == nil
 
 	if q.Qtype == dns.TypeTXT {
 		if n.isOwnChallenge(q.Name) {
@@ -70,58 +141,143 @@ func (n *Nameserver) answer(q dns.Question, remoteAddr string) ([]dns.RR, int, b
 			r = append(r, txtRRs...)
 		}
 	}
-	if q.Qtype == dns.TypeMX {
-		if n.answeringForDomain(q.Name) {
-			amx := new(dns.MX)
-			amx.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: uint32(n.Config.NS.Ttl)}
-			amx.Mx = dns.Fqdn(n.Config.NS.DefaultDomain)
-			amx.Preference = 10
-			r = append(r, amx)
+	profile := n.profileFor(q.Name)
+	answering := n.answeringForDomain(q.Name)
+	n.mu.RUnlock()
+
+	event := a.event
+	event.ServerName = q.Name
+	event.Data["profile"] = profile.Name
+	if len(r) == 0 && profile.Passthrough && util.ShouldRewrite(q.Name, config.Rewrites) {
+		// Mirror the records of the rewrite target
+		r, a.rcode = n.mirror(q, profile, event, config)
+	} else {
+		if answering {
+			r = append(r, n.dynamicRecords(q, profile, event, config)...)
+			// Names with synthesized records exist for all the types
+			if len(profile.types) > 0 {
+				nameExists = true
+			}
 		}
-	}
-	if q.Qtype == dns.TypeA {
-		if n.answeringForDomain(q.Name) {
-			a := new(dns.A)
-			a.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(n.Config.NS.Ttl)}
-			ipv4Addr, _, _ := net.ParseCIDR(n.Config.NS.NSResponseIP + "/32")
-			a.A = ipv4Addr
-			r = append(r, a)
+		if len(r) > 0 {
+			nameExists = true
 		}
-	}
-	if q.Qtype == dns.TypeCNAME {
-		if !util.HasApexDomain(q.Name, n.Config.NS.DefaultDomain) {
-			// Do not answer CNAMES for the default domain to prevent endless loops because of some resolvers
-			if n.answeringForDomain(q.Name) {
-				cn := new(dns.CNAME)
-				cn.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: uint32(n.Config.NS.Ttl)}
-				cn.Target = fmt.Sprintf("%s.%s.", uuid.New().String(), n.Config.NS.DefaultDomain)
-				r = append(r, cn)
-				// Add the A answer for the CNAME target to the response
-				a := new(dns.A)
-				a.Hdr = dns.RR_Header{Name: cn.Target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(n.Config.NS.Ttl)}
-				ipv4Addr, _, _ := net.ParseCIDR(n.Config.NS.NSResponseIP + "/32")
-				a.A = ipv4Addr
-				r = append(r, a)
-			}
+		if !a.authoritative {
+			a.rcode = dns.RcodeRefused
+		} else if !nameExists {
+			a.rcode = dns.RcodeNameError
 		}
 	}
-	if len(r) > 0 {
-		// Make sure that we return NOERROR if there were dynamic records for the domain
-		rcode = dns.RcodeSuccess
+	a.records = r
+	return a
+}
+
+// recordAnswer stores the event of the answered question, and logs and notifies it
+func (n *Nameserver) recordAnswer(a *questionAnswer, remote net.Addr, options clientOptions) {
+	q, rcode, event := a.q, a.rcode, a.event
+	remoteAddr := remote.String()
+	event.Data["qtype"] = dns.TypeToString[q.Qtype]
+	event.Data["rcode"] = dns.RcodeToString[rcode]
+	event.Data["transport"] = remote.Network()
+	event.Data["family"] = addrFamily(remoteAddr)
+	if options.udpSize > 0 {
+		event.Data["ednsSize"] = strconv.Itoa(int(options.udpSize))
+	}
+	if options.subnet != "" {
+		// The Client Subnet shows the network of the client behind a public resolver
+		event.Data["ecs"] = options.subnet
 	}
+	if options.cookie != "" {
+		event.Data["cookie"] = options.cookie
+	}
+	// The classification is in the notification as well, so that the notification filters can leave out the resolvers
+	clientLines := ""
+	if options.client.Class != "" {
+		event.Data["class"] = options.client.Class
+		event.Data["tags"] = strings.Join(options.client.Tags, ",")
+		clientLines = fmt.Sprintf("\nClass:   %s\nTags:    %s", event.Data["class"], event.Data["tags"])
+	}
+	n.Events.Record(event)
 	n.Notification.Notify("dns", fmt.Sprintf(`
 DNS question from: %s
-Type:   %s
-Rcode:  %s
-Domain: %s`,
-		remoteAddr, dns.TypeToString[q.Qtype], dns.RcodeToString[rcode], q.Name))
+Type:    %s
+Rcode:   %s
+Domain:  %s%s
+Session: %s`,
+		remoteAddr, dns.TypeToString[q.Qtype], dns.RcodeToString[rcode], q.Name, clientLines, event.SessionID))
 
 	n.Logger.Infow("Answering question for domain",
 		"qtype", dns.TypeToString[q.Qtype],
 		"domain", q.Name,
 		"rcode", dns.RcodeToString[rcode],
-		"remoteAddr", remoteAddr)
-	return r, rcode, authoritative, nil
+		"remoteAddr", remoteAddr,
+		"family", event.Data["family"],
+		"ecs", options.subnet,
+		"class", options.client.Class,
+		"session", event.SessionID)
+}
+
+// dynamicRecords synthesizes the records for the question according to the response profile
+func (n *Nameserver) dynamicRecords(q dns.Question, p *profile, event *certainly.Event, config *certainly.CertainlyCFG) []dns.RR {
+	r := []dns.RR{}
+	if !p.synthesizes(q.Qtype) {
+		return r
+	}
+	ttl := uint32(config.NS.Ttl)
+	switch q.Qtype {
+	case dns.TypeMX:
+		amx := new(dns.MX)
+		amx.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: ttl}
+		amx.Mx = dns.Fqdn(p.MX)
+		amx.Preference = 10
+		r = append(r, amx)
+	case dns.TypeA:
+		r = append(r, n.dynamicA(q.Name, p.A, ttl)...)
+	case dns.TypeAAAA:
+		r = append(r, n.dynamicAAAA(q.Name, p.AAAA, ttl)...)
+	case dns.TypeCNAME:
+		// Do not answer CNAMES for the default domain to prevent endless loops because of some resolvers
+		if util.HasApexDomain(q.Name, config.NS.DefaultDomain) {
+			return r
+		}
+		cn := new(dns.CNAME)
+		cn.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}
+		if p.CNAME != "" {
+			cn.Target = dns.Fqdn(p.CNAME)
+			return append(r, cn)
+		}
+		event.CorrelationID = uuid.New().String()
+		cn.Target = fmt.Sprintf("%s.%s.", event.CorrelationID, config.NS.DefaultDomain)
+		r = append(r, cn)
+		// Add the A and AAAA answers for the CNAME target to the response
+		r = append(r, n.dynamicA(cn.Target, p.A, ttl)...)
+		r = append(r, n.dynamicAAAA(cn.Target, p.AAAA, ttl)...)
+	}
+	return r
+}
+
+// dynamicA returns the A record pointing to ip for name
+func (n *Nameserver) dynamicA(name, ip string, ttl uint32) []dns.RR {
+	ipv4Addr := net.ParseIP(ip).To4()
+	if ipv4Addr == nil {
+		return []dns.RR{}
+	}
+	a := new(dns.A)
+	a.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}
+	a.A = ipv4Addr
+	return []dns.RR{a}
+}
+
+// dynamicAAAA returns the AAAA record pointing to ip for name, if it's a valid IPv6 address
+func (n *Nameserver) dynamicAAAA(name, ip string, ttl uint32) []dns.RR {
+	ipv6Addr := net.ParseIP(ip)
+	if ipv6Addr == nil || ipv6Addr.To4() != nil {
+		return []dns.RR{}
+	}
+	aaaa := new(dns.AAAA)
+	aaaa.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}
+	aaaa.AAAA = ipv6Addr
+	return []dns.RR{aaaa}
 }
 
 func (n *Nameserver) isAuthoritative(q dns.Question) bool {
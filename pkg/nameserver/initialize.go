@@ -1,7 +1,6 @@
 package nameserver
 
 import (
-	"fmt"
 	"strings"
 	"sync"
 
@@ -9,7 +8,12 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/classify"
+	"github.com/happycakefriends/certainly/pkg/dnssec"
+	"github.com/happycakefriends/certainly/pkg/events"
 	"github.com/happycakefriends/certainly/pkg/notification"
+	"github.com/happycakefriends/certainly/pkg/ratelimit"
+	"github.com/happycakefriends/certainly/pkg/resolver"
 )
 
 // Records is a slice of ResourceRecords
@@ -18,91 +22,63 @@ type Records struct {
 }
 
 type Nameserver struct {
-	Config            *certainly.CertainlyCFG
-	Logger            *zap.SugaredLogger
-	Notification      *notification.Notifications
-	Server            *dns.Server
-	OwnDomains        []string
-	NotifyStartedFunc func()
-	SOA               dns.RR
-	ownChallenges     map[string]string
-	Domains           map[string]Records
-	errChan           chan error
+	Config         *certainly.CertainlyCFG
+	Logger         *zap.SugaredLogger
+	Notification   *notification.Notifications
+	Events         *events.Events
+	Limiter        *ratelimit.Limiter
+	OwnDomains     []string
+	SOA            dns.RR
+	ownChallenges  map[string]string
+	Domains        map[string]Records
+	upstream       *resolver.Resolver
+	keys           map[string]*dnssec.Key
+	journal        map[string][]zoneDiff
+	loadedRecords  []dns.RR
+	profiles       []*profile
+	defaultProfile *profile
+	cookieSecret   []byte
+	classifier     *classify.Classifier
+
+	// mu guards OwnDomains, Domains, ownChallenges, keys and journal, which are read while answering and changed at runtime
+	mu sync.RWMutex
 }
 
-func InitAndStart(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) []certainly.CertainlyNS {
-	dnsservers := make([]certainly.CertainlyNS, 0)
-	waitLock := sync.Mutex{}
-	if strings.HasPrefix(config.NS.Proto, "both") {
-		// Handle the case where DNS server should be started for both udp and tcp
-		udpProto := "udp"
-		tcpProto := "tcp"
-		if strings.HasSuffix(config.NS.Proto, "4") {
-			udpProto += "4"
-			tcpProto += "4"
-		} else if strings.HasSuffix(config.NS.Proto, "6") {
-			udpProto += "6"
-			tcpProto += "6"
-		}
-		dnsServerUDP := NewDNSServer(config, logger, udpProto, notification)
-		dnsservers = append(dnsservers, dnsServerUDP)
-		dnsServerUDP.ParseRecords()
-		dnsServerTCP := NewDNSServer(config, logger, tcpProto, notification)
-		dnsservers = append(dnsservers, dnsServerTCP)
-		dnsServerTCP.ParseRecords()
-		// wait for the server to get started to proceed
-		waitLock.Lock()
-		dnsServerUDP.SetNotifyStartedFunc(waitLock.Unlock)
-		go dnsServerUDP.Start(errChan)
-		waitLock.Lock()
-		dnsServerTCP.SetNotifyStartedFunc(waitLock.Unlock)
-		go dnsServerTCP.Start(errChan)
-		waitLock.Lock()
-	} else {
-		dnsServer := NewDNSServer(config, logger, config.NS.Proto, notification)
-		dnsservers = append(dnsservers, dnsServer)
-		dnsServer.ParseRecords()
-		waitLock.Lock()
-		dnsServer.SetNotifyStartedFunc(waitLock.Unlock)
-		go dnsServer.Start(errChan)
-		waitLock.Lock()
-	}
-	return dnsservers
+// Initialize creates the nameserver and parses the records, the listeners are started by the lifecycle manager
+func Initialize(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, notification *notification.Notifications, events *events.Events, limiter *ratelimit.Limiter) certainly.CertainlyNS {
+	dnsServer := NewDNSServer(config, logger, notification, events, limiter)
+	dnsServer.ParseRecords()
+	return dnsServer
 }
 
-// NewDNSServer parses the DNS records from config and returns a new DNSServer struct
-func NewDNSServer(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, proto string, notifications *notification.Notifications) certainly.CertainlyNS {
-	//		dnsServerTCP := NewDNSServer(DB, Config.General.Listen, tcpProto, Config.General.Domain)
-	server := Nameserver{Config: config, Logger: logger, Notification: notifications}
-	server.Server = &dns.Server{Addr: fmt.Sprintf("%s:%s", config.General.IP, config.NS.Port), Net: proto}
-	od := []string{}
-	for _, d := range config.NS.Domains {
-		if !strings.HasSuffix(d, ".") {
-			d = d + "."
-		}
-		od = append(od, strings.ToLower(d))
-	}
-	server.OwnDomains = od
+// NewDNSServer returns a new nameserver with its own handler
+func NewDNSServer(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, notifications *notification.Notifications, events *events.Events, limiter *ratelimit.Limiter) *Nameserver {
+	server := &Nameserver{Config: config, Logger: logger, Notification: notifications, Events: events, Limiter: limiter}
+	server.OwnDomains = configDomains(config)
 	server.ownChallenges = make(map[string]string)
 	server.Domains = make(map[string]Records)
-	return &server
+	server.keys = make(map[string]*dnssec.Key)
+	server.journal = make(map[string][]zoneDiff)
+	server.upstream = newMirrorResolver(config)
+	server.cookieSecret = newCookieSecret()
+	server.classifier = classify.New(config, logger)
+	server.compileProfiles()
+	return server
 }
 
-func (n *Nameserver) Start(errorChannel chan error) {
-	n.errChan = errorChannel
-	dns.HandleFunc(".", n.handleRequest)
-	n.Logger.Infow("Starting DNS listener",
-		"addr", n.Server.Addr,
-		"proto", n.Server.Net)
-	if n.NotifyStartedFunc != nil {
-		n.Server.NotifyStartedFunc = n.NotifyStartedFunc
+// configDomains returns the apex domains managed according to the configuration
+func configDomains(config *certainly.CertainlyCFG) []string {
+	od := []string{}
+	// The default domain hosts the CNAME targets, so it's always managed
+	managed := append([]string{config.NS.DefaultDomain}, config.NS.Domains...)
+	for d := range config.NS.ZoneFiles {
+		managed = append(managed, d)
 	}
-	err := n.Server.ListenAndServe()
-	if err != nil {
-		errorChannel <- err
+	for _, d := range managed {
+		d = strings.ToLower(dns.Fqdn(d))
+		if d != "." && !containsDomain(od, d) {
+			od = append(od, d)
+		}
 	}
-}
-
-func (n *Nameserver) SetNotifyStartedFunc(fun func()) {
-	n.Server.NotifyStartedFunc = fun
+	return od
 }
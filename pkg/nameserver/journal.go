@@ -0,0 +1,155 @@
+package nameserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxJournal is the number of changes kept per zone for answering IXFR requests
+const maxJournal = 100
+
+// zoneDiff is a single change to a zone, as transferred in IXFR responses
+type zoneDiff struct {
+	from    *dns.SOA
+	to      *dns.SOA
+	deleted []dns.RR
+	added   []dns.RR
+}
+
+// insertRR adds the record unless an identical one, ignoring the TTL, already exists
+func (n *Nameserver) insertRR(rr dns.RR) bool {
+	for _, existing := range n.Domains[rr.Header().Name].Records {
+		if dns.IsDuplicate(existing, rr) {
+			return false
+		}
+	}
+	n.appendRR(rr)
+	return true
+}
+
+// removeRRs removes the records of the name that match and returns the removed records
+func (n *Nameserver) removeRRs(name string, match func(dns.RR) bool) []dns.RR {
+	removed := []dns.RR{}
+	recs := []dns.RR{}
+	for _, rr := range n.Domains[name].Records {
+		if match(rr) {
+			removed = append(removed, rr)
+		} else {
+			recs = append(recs, rr)
+		}
+	}
+	if len(removed) == 0 {
+		return removed
+	}
+	if len(recs) == 0 {
+		delete(n.Domains, name)
+	} else {
+		n.Domains[name] = Records{recs}
+	}
+	return removed
+}
+
+// zoneChanged bumps the serial of the zone unless the change already replaced it with a newer one, stores the change
+// for IXFR and notifies the secondaries. old is a copy of the SOA record from before the change.
+func (n *Nameserver) zoneChanged(apex string, old *dns.SOA, added, deleted []dns.RR) {
+	soa := n.zoneSOA(apex)
+	if soa == nil || old == nil {
+		return
+	}
+	if !serialNewer(soa.Serial, old.Serial) {
+		// The records are shared with the responses that are still being written, so the SOA record is replaced with a
+		// copy instead of changing the serial in place
+		bumped := dns.Copy(soa).(*dns.SOA)
+		bumped.Serial = old.Serial + 1
+		n.replaceSOA(apex, soa, bumped)
+		soa = bumped
+	}
+	diff := zoneDiff{from: old, to: dns.Copy(soa).(*dns.SOA)}
+	for _, rr := range deleted {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			diff.deleted = append(diff.deleted, rr)
+		}
+	}
+	for _, rr := range added {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			diff.added = append(diff.added, rr)
+		}
+	}
+	n.journal[apex] = append(n.journal[apex], diff)
+	if len(n.journal[apex]) > maxJournal {
+		n.journal[apex] = n.journal[apex][len(n.journal[apex])-maxJournal:]
+	}
+	n.Logger.Infow("Zone changed",
+		"domain", apex,
+		"serial", soa.Serial,
+		"added", len(diff.added),
+		"deleted", len(diff.deleted))
+	go n.notifySecondaries(apex, n.Config.NS.NotifySecondaries)
+}
+
+// replaceSOA replaces the SOA record of the zone in a new record slice
+func (n *Nameserver) replaceSOA(apex string, old, soa *dns.SOA) {
+	recs := make([]dns.RR, 0, len(n.Domains[apex].Records))
+	for _, rr := range n.Domains[apex].Records {
+		if rr == dns.RR(old) {
+			rr = soa
+		}
+		recs = append(recs, rr)
+	}
+	n.Domains[apex] = Records{recs}
+	if n.SOA == old {
+		n.SOA = soa
+	}
+}
+
+// zoneSOACopy returns a copy of the SOA record of the zone to compare against after a change
+func (n *Nameserver) zoneSOACopy(apex string) *dns.SOA {
+	soa := n.zoneSOA(apex)
+	if soa == nil {
+		return nil
+	}
+	return dns.Copy(soa).(*dns.SOA)
+}
+
+// journalSince returns the changes made after the serial, or false if the journal doesn't reach back that far
+func (n *Nameserver) journalSince(apex string, serial uint32) ([]zoneDiff, bool) {
+	for i, diff := range n.journal[apex] {
+		if diff.from.Serial == serial {
+			return n.journal[apex][i:], true
+		}
+	}
+	return nil, false
+}
+
+// notifySecondaries sends a NOTIFY message for the zone to the secondaries
+func (n *Nameserver) notifySecondaries(apex string, secondaries []string) {
+	for _, addr := range secondaries {
+		m := new(dns.Msg)
+		m.SetNotify(apex)
+		c := &dns.Client{Timeout: 5 * time.Second}
+		var err error
+		// Retry a couple of times, NOTIFY is sent over UDP
+		for i := 0; i < 3; i++ {
+			var in *dns.Msg
+			in, _, err = c.Exchange(m, addr)
+			if err == nil && in.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("secondary answered with %s", dns.RcodeToString[in.Rcode])
+			}
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			n.Logger.Errorw("Could not notify secondary",
+				"domain", apex,
+				"secondary", addr,
+				"error", err)
+			continue
+		}
+		n.Logger.Debugw("Notified secondary",
+			"domain", apex,
+			"secondary", addr)
+	}
+}
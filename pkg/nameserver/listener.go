@@ -0,0 +1,105 @@
+package nameserver
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// dnsListener is a single address and network of the nameserver
+type dnsListener struct {
+	n       *Nameserver
+	addr    string
+	network string
+	srv     *dns.Server
+	started chan struct{}
+}
+
+// Listeners returns a listener for every configured address and protocol
+func (n *Nameserver) Listeners() []certainly.Listener {
+	listeners := []certainly.Listener{}
+	for _, addr := range n.Config.NS.Listen {
+		for _, network := range listenNetworks(n.Config.NS.Proto, addr) {
+			listeners = append(listeners, &dnsListener{n: n, addr: addr, network: network})
+		}
+	}
+	return listeners
+}
+
+func (l *dnsListener) Name() string {
+	return "dns " + l.addr + "/" + l.network
+}
+
+// Listen binds the socket for a new server, a server that has failed can't be started again
+func (l *dnsListener) Listen() error {
+	started := make(chan struct{})
+	l.n.mu.RLock()
+	secrets := tsigSecrets(l.n.Config.NS.TSIGKeys)
+	l.n.mu.RUnlock()
+	srv := &dns.Server{
+		Addr:              l.addr,
+		Net:               l.network,
+		Handler:           dns.HandlerFunc(l.n.handleRequest),
+		TsigSecret:        secrets,
+		MsgAcceptFunc:     acceptMessage,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	if strings.HasPrefix(l.network, "udp") {
+		pc, err := net.ListenPacket(l.network, l.addr)
+		if err != nil {
+			return err
+		}
+		srv.PacketConn = pc
+	} else {
+		ln, err := net.Listen(l.network, l.addr)
+		if err != nil {
+			return err
+		}
+		srv.Listener = ln
+	}
+	l.srv, l.started = srv, started
+	return nil
+}
+
+func (l *dnsListener) Serve() error {
+	return l.srv.ActivateAndServe()
+}
+
+// Shutdown waits for the in-flight requests to be answered until the context is done
+func (l *dnsListener) Shutdown(ctx context.Context) error {
+	// The server can only be shut down once it has started serving the socket
+	select {
+	case <-l.started:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return l.srv.ShutdownContext(ctx)
+}
+
+// listenNetworks returns the networks to listen on for the address. Without an explicit IP version in the protocol
+// it's picked from the address, so that the IPv4 and IPv6 wildcard addresses can be listened on side by side.
+func listenNetworks(proto, addr string) []string {
+	networks := []string{strings.TrimRight(proto, "46")}
+	if strings.HasPrefix(proto, "both") {
+		networks = []string{"udp", "tcp"}
+	}
+	version := ""
+	if strings.HasSuffix(proto, "4") || strings.HasSuffix(proto, "6") {
+		version = proto[len(proto)-1:]
+	} else if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			version = "6"
+			if ip.To4() != nil {
+				version = "4"
+			}
+		}
+	}
+	for i := range networks {
+		networks[i] += version
+	}
+	return networks
+}
@@ -0,0 +1,83 @@
+package nameserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/resolver"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// newMirrorResolver creates the resolver used for mirroring the upstream zones. The answers are always cached
+// for their upstream TTL to avoid querying the upstream for every question.
+func newMirrorResolver(config *certainly.CertainlyCFG) *resolver.Resolver {
+	return resolver.New(config.Resolver.Upstreams, time.Duration(config.Resolver.Timeout)*time.Second, config.Resolver.Retries, true)
+}
+
+// mirror answers the question with the upstream records of the rewrite target, renaming the records
+// back to the questioned domain. Record types and labels selected in the profile are replaced with
+// the synthesized records.
+func (n *Nameserver) mirror(q dns.Question, p *profile, event *certainly.Event, config *certainly.CertainlyCFG) ([]dns.RR, int) {
+	// The question may have a randomized case
+	name := strings.ToLower(q.Name)
+	from, to, _ := util.RewriteFor(name, config.Rewrites)
+	if p.replacesLabel(name, from) {
+		event.Data["mirror"] = "replaced"
+		return n.dynamicRecords(q, p, event, config), dns.RcodeSuccess
+	}
+	upstreamName := util.ReplaceApex(name, config.Rewrites)
+	in, err := n.upstream.Query(upstreamName, q.Qtype)
+	if err != nil {
+		n.Logger.Errorw("Upstream query failed while mirroring",
+			"domain", q.Name,
+			"upstream", upstreamName,
+			"error", err)
+		return []dns.RR{}, dns.RcodeServerFailure
+	}
+	if p.replacesType(q.Qtype) && in.Rcode == dns.RcodeSuccess && len(in.Answer) > 0 {
+		// The name exists upstream, but should point to us
+		event.Data["mirror"] = "replaced"
+		return n.dynamicRecords(q, p, event, config), dns.RcodeSuccess
+	}
+	event.Data["mirror"] = "upstream"
+	r := []dns.RR{}
+	for _, rr := range in.Answer {
+		rr = dns.Copy(rr)
+		rr.Header().Name = reverseApex(rr.Header().Name, from, to)
+		if cn, ok := rr.(*dns.CNAME); ok {
+			cn.Target = reverseApex(cn.Target, from, to)
+		}
+		r = append(r, rr)
+	}
+	return r, in.Rcode
+}
+
+func (p *profile) replacesType(qtype uint16) bool {
+	return p.replaceTypes[qtype]
+}
+
+// replacesLabel checks if the leftmost label or the whole subdomain part of the name is selected for replacement
+func (p *profile) replacesLabel(name, apex string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	sub := strings.TrimSuffix(strings.TrimSuffix(name, strings.ToLower(apex)), ".")
+	label := strings.SplitN(name, ".", 2)[0]
+	for _, l := range p.ReplaceLabels {
+		l = strings.ToLower(l)
+		if (sub != "" && l == sub) || l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseApex renames the names under the rewrite target apex back to the rewrite source apex
+func reverseApex(name, from, to string) string {
+	if !util.InDomain(name, to) {
+		return name
+	}
+	fqdn := dns.Fqdn(strings.ToLower(name))
+	return strings.TrimSuffix(fqdn, dns.Fqdn(to)) + dns.Fqdn(from)
+}
@@ -0,0 +1,260 @@
+package nameserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
+	"github.com/happycakefriends/certainly/pkg/notification"
+)
+
+// stubUpstream answers like a recursive resolver for the rewrite target google.com
+func stubUpstream(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	add := func(s string) {
+		rr, _ := dns.NewRR(s)
+		m.Answer = append(m.Answer, rr)
+	}
+	q := r.Question[0]
+	switch {
+	case q.Name == "www.google.com." && q.Qtype == dns.TypeA:
+		add("www.google.com. 300 IN CNAME edge.google.com.")
+		add("edge.google.com. 300 IN A 142.250.74.101")
+	case strings.HasSuffix(q.Name, ".google.com."):
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+	_ = w.WriteMsg(m)
+}
+
+// serve starts a DNS server for the handler on a random loopback port and returns its address
+func serve(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe() //nolint:all
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func testConfig(upstream string) *certainly.CertainlyCFG {
+	config := &certainly.CertainlyCFG{}
+	config.NS.Listen = []string{"127.0.0.1:0"}
+	config.NS.Proto = "udp"
+	config.NS.DefaultDomain = "mom.tld"
+	config.NS.Domains = []string{"mom.tld", "coogle.com", "woogle.com"}
+	config.NS.Nsname = "ns.mom.tld"
+	config.NS.Nsadmin = "admin.mom.tld"
+	config.NS.NSResponseIP = "203.0.113.5"
+	config.NS.Ttl = 60
+	config.NS.EDNSMaxUDPSize = 1232
+	config.NS.Profiles = []certainly.NSProfile{
+		{Name: "honest", Domains: []string{"woogle.com"}, Passthrough: true, ReplaceLabels: []string{"login"}},
+	}
+	config.Rewrites = map[string]string{"coogle.com": "google.com", "woogle.com": "google.com"}
+	config.Resolver.Upstreams = []string{upstream}
+	config.Resolver.Timeout = 1
+	config.Events.SessionWindow = 600
+	return config
+}
+
+// startNameserver starts a standalone nameserver with its own listeners and returns the address it answers on
+func startNameserver(t *testing.T, config *certainly.CertainlyCFG) (*Nameserver, string) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	notifications := notification.Initialize(config, logger)
+	n := NewDNSServer(config, logger, notifications, events.Initialize(config, logger), nil)
+	n.ParseRecords()
+	listeners := n.Listeners()
+	if len(listeners) != 1 {
+		t.Fatalf("got %d listeners, want 1", len(listeners))
+	}
+	l := listeners[0].(*dnsListener)
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go l.Serve() //nolint:all
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = l.Shutdown(ctx)
+		_ = notifications.Close(ctx)
+	})
+	if l.srv.PacketConn == nil {
+		return n, l.srv.Listener.Addr().String()
+	}
+	return n, l.srv.PacketConn.LocalAddr().String()
+}
+
+func query(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	c := &dns.Client{Timeout: 2 * time.Second}
+	in, _, err := c.Exchange(m, addr)
+	if err != nil {
+		t.Fatalf("%s %s: %s", name, dns.TypeToString[qtype], err)
+	}
+	return in
+}
+
+// addrs returns the A record addresses of the answer
+func addrs(in *dns.Msg) []string {
+	res := []string{}
+	for _, rr := range in.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			res = append(res, a.A.String())
+		}
+	}
+	return res
+}
+
+func TestRandomizedCaseQuestions(t *testing.T) {
+	_, addr := startNameserver(t, testConfig(serve(t, dns.HandlerFunc(stubUpstream))))
+	tests := []struct {
+		name  string
+		addrs []string
+	}{
+		// The passthrough profile mirrors the upstream records of the rewrite target
+		{"www.woogle.com.", []string{"142.250.74.101"}},
+		{"wWw.WoOgLe.CoM.", []string{"142.250.74.101"}},
+		// Replaced labels are synthesized even in passthrough mode
+		{"LoGiN.wOOgle.com.", []string{"203.0.113.5"}},
+		// The default profile synthesizes the records
+		{"WwW.cOoGlE.cOm.", []string{"203.0.113.5"}},
+	}
+	for _, test := range tests {
+		in := query(t, addr, test.name, dns.TypeA)
+		if in.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: rcode %s, want NOERROR", test.name, dns.RcodeToString[in.Rcode])
+			continue
+		}
+		if got := addrs(in); strings.Join(got, ",") != strings.Join(test.addrs, ",") {
+			t.Errorf("%s: addresses %v, want %v", test.name, got, test.addrs)
+		}
+	}
+}
+
+func TestStandaloneNameservers(t *testing.T) {
+	upstream := serve(t, dns.HandlerFunc(stubUpstream))
+	first := testConfig(upstream)
+	second := testConfig(upstream)
+	second.NS.NSResponseIP = "198.51.100.7"
+	second.NS.Domains = []string{"mom.tld", "coogle.com"}
+	second.NS.Profiles = nil
+	second.Rewrites = map[string]string{"coogle.com": "google.com"}
+	_, firstAddr := startNameserver(t, first)
+	_, secondAddr := startNameserver(t, second)
+
+	if got := addrs(query(t, firstAddr, "www.coogle.com.", dns.TypeA)); len(got) != 1 || got[0] != "203.0.113.5" {
+		t.Errorf("first nameserver answered %v, want [203.0.113.5]", got)
+	}
+	if got := addrs(query(t, secondAddr, "www.coogle.com.", dns.TypeA)); len(got) != 1 || got[0] != "198.51.100.7" {
+		t.Errorf("second nameserver answered %v, want [198.51.100.7]", got)
+	}
+	if in := query(t, secondAddr, "www.woogle.com.", dns.TypeA); in.Rcode != dns.RcodeRefused {
+		t.Errorf("second nameserver answered %s for a domain it doesn't manage, want REFUSED", dns.RcodeToString[in.Rcode])
+	}
+}
+
+func TestZoneFileOutsideOrigin(t *testing.T) {
+	dir := t.TempDir()
+	zone := filepath.Join(dir, "zone.example.zone")
+	contents := "$TTL 60\n" +
+		"@ IN NS ns.mom.tld.\n" +
+		"www IN A 192.0.2.10\n" +
+		"www.google.com. IN A 192.0.2.66\n"
+	if err := os.WriteFile(zone, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(serve(t, dns.HandlerFunc(stubUpstream)))
+	config.NS.ZoneFiles = map[string][]string{"zone.example": {zone}}
+	n, addr := startNameserver(t, config)
+
+	_, errs := n.zoneFileRecords(config)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), zone+":4:") {
+		t.Errorf("zone file errors %v, want an error at %s:4", errs, zone)
+	}
+	// The file is skipped as a whole, and certainly doesn't become authoritative for the other domain
+	for _, a := range addrs(query(t, addr, "www.zone.example.", dns.TypeA)) {
+		if a == "192.0.2.10" {
+			t.Errorf("www.zone.example answered %s from a rejected zone file", a)
+		}
+	}
+	if in := query(t, addr, "www.google.com.", dns.TypeA); in.Rcode != dns.RcodeRefused || len(in.Answer) != 0 {
+		t.Errorf("www.google.com answered %s %v, want REFUSED", dns.RcodeToString[in.Rcode], addrs(in))
+	}
+}
+
+func TestAddRecordOutsideOwnDomains(t *testing.T) {
+	n, addr := startNameserver(t, testConfig(serve(t, dns.HandlerFunc(stubUpstream))))
+	tests := []struct {
+		record  string
+		wantErr bool
+	}{
+		{"www.coogle.com. 60 IN A 192.0.2.10", false},
+		{"deep.sub.mom.tld. 60 IN TXT hello", false},
+		{"www.google.com. 60 IN A 192.0.2.66", true},
+		// Only the whole labels of the managed domains count
+		{"notcoogle.com. 60 IN A 192.0.2.66", true},
+	}
+	for _, test := range tests {
+		if err := n.AddRecord(test.record); (err != nil) != test.wantErr {
+			t.Errorf("AddRecord(%s) error = %v, want error %t", test.record, err, test.wantErr)
+		}
+	}
+	if in := query(t, addr, "www.google.com.", dns.TypeA); in.Rcode != dns.RcodeRefused || len(in.Answer) != 0 {
+		t.Errorf("www.google.com answered %s %v, want REFUSED", dns.RcodeToString[in.Rcode], addrs(in))
+	}
+}
+
+// soaSerial returns the serial of the SOA record in the answer, or 0 if there's none
+func soaSerial(in *dns.Msg) uint32 {
+	for _, rr := range in.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial
+		}
+	}
+	return 0
+}
+
+func TestSerialBumpWhileAnswering(t *testing.T) {
+	n, addr := startNameserver(t, testConfig(serve(t, dns.HandlerFunc(stubUpstream))))
+	start := soaSerial(query(t, addr, "coogle.com.", dns.TypeSOA))
+	if start == 0 {
+		t.Fatal("no SOA record for coogle.com")
+	}
+	const changes = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < changes; i++ {
+			if err := n.AddRecord(fmt.Sprintf("r%d.coogle.com. 60 IN A 192.0.2.%d", i, i+1)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < changes; i++ {
+		query(t, addr, "coogle.com.", dns.TypeSOA)
+	}
+	<-done
+	if serial := soaSerial(query(t, addr, "coogle.com.", dns.TypeSOA)); serial != start+changes {
+		t.Errorf("serial %d after %d changes, want %d", serial, changes, start+changes)
+	}
+}
@@ -3,37 +3,47 @@ package nameserver
 import (
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
 )
 
 // ParseRecords parses a slice of DNS record string
 func (n *Nameserver) ParseRecords() {
-	for _, v := range n.Config.NS.StaticRecords {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	records, errs := n.configRecords(n.Config)
+	for _, err := range errs {
+		n.Logger.Errorw("Could not load records from config",
+			"error", err.Error())
+	}
+	for _, rr := range records {
+		n.appendRR(rr)
+	}
+	n.loadedRecords = records
+	n.addZones()
+	n.loadKeys()
+}
+
+// configRecords parses the static records and the zone files of the configuration. The records that can't be
+// parsed are left out and returned as errors.
+func (n *Nameserver) configRecords(config *certainly.CertainlyCFG) ([]dns.RR, []error) {
+	records := []dns.RR{}
+	errs := []error{}
+	for _, v := range config.NS.StaticRecords {
 		rr, err := dns.NewRR(strings.ToLower(v))
 		if err != nil {
-			n.Logger.Errorw("Could not parse RR from config",
-				"error", err.Error(),
-				"rr", v)
+			errs = append(errs, fmt.Errorf("record %q: %w", v, err))
 			continue
 		}
-		// Add parsed RR
-		n.appendRR(rr)
-	}
-	// Create serial
-	serial := time.Now().Format("2006010215")
-	// Add SOA
-	SOAstring := fmt.Sprintf("%s. SOA %s. %s. %s 28800 7200 604800 86400", strings.ToLower(n.Config.NS.DefaultDomain), strings.ToLower(n.Config.NS.Nsname), strings.ToLower(n.Config.NS.Nsadmin), serial)
-	soarr, err := dns.NewRR(SOAstring)
-	if err != nil {
-		n.Logger.Errorw("Error while adding SOA record",
-			"error", err.Error(),
-			"soa", SOAstring)
-	} else {
-		n.appendRR(soarr)
-		n.SOA = soarr
+		if rr == nil {
+			continue
+		}
+		records = append(records, rr)
 	}
+	zoneRecords, zoneErrs := n.zoneFileRecords(config)
+	return append(records, zoneRecords...), append(errs, zoneErrs...)
 }
 
 func (n *Nameserver) appendRR(rr dns.RR) {
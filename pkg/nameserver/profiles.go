@@ -0,0 +1,109 @@
+package nameserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// defaultProfileTypes are the record types synthesized when the profile doesn't define them
+var defaultProfileTypes = []string{"A", "AAAA", "MX", "CNAME"}
+
+// profile is a compiled response profile
+type profile struct {
+	certainly.NSProfile
+	regexes      []*regexp.Regexp
+	types        map[uint16]bool
+	replaceTypes map[uint16]bool
+}
+
+// compileProfiles compiles the response profiles from the config, filling in the default values
+func (n *Nameserver) compileProfiles() {
+	n.profiles = []*profile{}
+	for i, p := range n.Config.NS.Profiles {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("profile%d", i+1)
+		}
+		compiled := n.newProfile(p)
+		for _, r := range p.Regexes {
+			re, err := regexp.Compile(r)
+			if err != nil {
+				n.Logger.Errorw("Could not compile profile regex",
+					"profile", p.Name,
+					"regex", r,
+					"error", err)
+				continue
+			}
+			compiled.regexes = append(compiled.regexes, re)
+		}
+		n.profiles = append(n.profiles, compiled)
+	}
+	n.defaultProfile = n.newProfile(certainly.NSProfile{Name: "default"})
+}
+
+func (n *Nameserver) newProfile(p certainly.NSProfile) *profile {
+	if p.A == "" {
+		p.A = n.Config.NS.NSResponseIP
+	}
+	if p.AAAA == "" {
+		p.AAAA = n.Config.NS.NSResponseIP6
+	}
+	if p.MX == "" {
+		p.MX = n.Config.NS.DefaultDomain
+	}
+	if len(p.Types) == 0 {
+		p.Types = defaultProfileTypes
+	}
+	compiled := &profile{NSProfile: p}
+	compiled.types = n.profileTypes(p.Name, p.Types)
+	compiled.replaceTypes = n.profileTypes(p.Name, p.ReplaceTypes)
+	return compiled
+}
+
+func (n *Nameserver) profileTypes(name string, types []string) map[uint16]bool {
+	qtypes := make(map[uint16]bool)
+	for _, t := range types {
+		if qtype, ok := dns.StringToType[strings.ToUpper(t)]; ok {
+			qtypes[qtype] = true
+		} else {
+			n.Logger.Errorw("Unknown record type in profile",
+				"profile", name,
+				"type", t)
+		}
+	}
+	return qtypes
+}
+
+// profileFor returns the first profile matching the name, or the default profile
+func (n *Nameserver) profileFor(name string) *profile {
+	for _, p := range n.profiles {
+		if p.matches(name) {
+			return p
+		}
+	}
+	return n.defaultProfile
+}
+
+func (p *profile) matches(name string) bool {
+	for _, d := range p.Domains {
+		if util.InDomain(name, d) {
+			return true
+		}
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, re := range p.regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *profile) synthesizes(qtype uint16) bool {
+	return p.types[qtype]
+}
@@ -0,0 +1,147 @@
+package nameserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// ErrNotFound is returned when the record or domain to delete doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ListDomains returns the managed apex domains
+func (n *Nameserver) ListDomains() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	domains := make([]string, len(n.OwnDomains))
+	copy(domains, n.OwnDomains)
+	return domains
+}
+
+// AddDomain starts managing a new apex domain, creating its SOA and NS records
+func (n *Nameserver) AddDomain(domain string) error {
+	apex := strings.ToLower(dns.Fqdn(domain))
+	if _, ok := dns.IsDomainName(apex); !ok || apex == "." {
+		return fmt.Errorf("invalid domain name %s", domain)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if containsDomain(n.OwnDomains, apex) {
+		return nil
+	}
+	n.addDomain(apex, time.Now().Format("2006010215"))
+	return nil
+}
+
+func (n *Nameserver) addDomain(apex, serial string) {
+	n.OwnDomains = append(n.OwnDomains, apex)
+	n.addZone(apex, serial)
+	if n.Config.NS.DNSSEC {
+		n.loadKey(apex)
+	}
+}
+
+// DeleteDomain stops managing an apex domain and removes all of its records, except the ones belonging to a more specific managed domain
+func (n *Nameserver) DeleteDomain(domain string) error {
+	apex := strings.ToLower(dns.Fqdn(domain))
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if strings.EqualFold(apex, dns.Fqdn(n.Config.NS.DefaultDomain)) {
+		return fmt.Errorf("the default domain %s can not be deleted", domain)
+	}
+	if !containsDomain(n.OwnDomains, apex) {
+		return fmt.Errorf("domain %s: %w", domain, ErrNotFound)
+	}
+	n.removeDomain(apex)
+	return nil
+}
+
+func (n *Nameserver) removeDomain(apex string) {
+	for name := range n.Domains {
+		if n.zoneApex(name) == apex {
+			delete(n.Domains, name)
+		}
+	}
+	od := []string{}
+	for _, d := range n.OwnDomains {
+		if d != apex {
+			od = append(od, d)
+		}
+	}
+	n.OwnDomains = od
+	delete(n.keys, apex)
+	delete(n.journal, apex)
+}
+
+// ListRecords returns the records of the name and its subdomains in zone file format, or all the records if name is empty
+func (n *Nameserver) ListRecords(name string) []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	records := []string{}
+	for d, recs := range n.Domains {
+		if name != "" && !util.InDomain(d, dns.Fqdn(name)) {
+			continue
+		}
+		for _, rr := range recs.Records {
+			records = append(records, rr.String())
+		}
+	}
+	sort.Strings(records)
+	return records
+}
+
+// AddRecord parses a record in zone file format and adds it to the static records. The record has to be in one of the
+// managed domains, so that it can't make certainly authoritative for other domains.
+func (n *Nameserver) AddRecord(record string) error {
+	rr, err := parseRecord(record)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	apex := n.zoneApex(rr.Header().Name)
+	if apex == "" {
+		return fmt.Errorf("record %s is outside of the managed domains", rr.Header().Name)
+	}
+	old := n.zoneSOACopy(apex)
+	if n.insertRR(rr) {
+		n.zoneChanged(apex, old, []dns.RR{rr}, nil)
+	}
+	return nil
+}
+
+// DeleteRecord removes the matching static record, the TTL is not compared
+func (n *Nameserver) DeleteRecord(record string) error {
+	rr, err := parseRecord(record)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	apex := n.zoneApex(rr.Header().Name)
+	old := n.zoneSOACopy(apex)
+	removed := n.removeRRs(rr.Header().Name, func(existing dns.RR) bool { return dns.IsDuplicate(existing, rr) })
+	if len(removed) == 0 {
+		return fmt.Errorf("record %s: %w", record, ErrNotFound)
+	}
+	n.zoneChanged(apex, old, nil, removed)
+	return nil
+}
+
+func parseRecord(record string) (dns.RR, error) {
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("no record found in %q", record)
+	}
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+	return rr, nil
+}
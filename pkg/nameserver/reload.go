@@ -0,0 +1,106 @@
+package nameserver
+
+import (
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// Reload applies a changed configuration to the running nameserver. Only the differences to the records and domains
+// loaded from the previous configuration are applied, so the changes made through the admin API and dynamic updates
+// are kept. The configuration is rejected as a whole if any of its records or zone files can't be parsed.
+func (n *Nameserver) Reload(config *certainly.CertainlyCFG) error {
+	records, errs := n.configRecords(config)
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if err := n.classifier.Reload(config); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	oldDomains := configDomains(n.Config)
+	newDomains := configDomains(config)
+	n.Config = config
+	n.compileProfiles()
+
+	for _, apex := range oldDomains {
+		if !containsDomain(newDomains, apex) && containsDomain(n.OwnDomains, apex) {
+			n.removeDomain(apex)
+			n.Logger.Infow("Removed domain",
+				"domain", apex)
+		}
+	}
+
+	// The SOA records from before the change, by zone
+	changed := map[string]*dns.SOA{}
+	added := map[string][]dns.RR{}
+	deleted := map[string][]dns.RR{}
+	touch := func(rr dns.RR) string {
+		apex := n.zoneApex(rr.Header().Name)
+		if _, ok := changed[apex]; !ok {
+			changed[apex] = n.zoneSOACopy(apex)
+		}
+		return apex
+	}
+	for _, rr := range n.loadedRecords {
+		if containsRR(records, rr) {
+			continue
+		}
+		apex := touch(rr)
+		match := func(existing dns.RR) bool { return dns.IsDuplicate(existing, rr) }
+		if rr.Header().Rrtype == dns.TypeSOA {
+			// The serial may have been bumped since
+			match = isType(dns.TypeSOA)
+		}
+		deleted[apex] = append(deleted[apex], n.removeRRs(rr.Header().Name, match)...)
+	}
+	serial := time.Now().Format("2006010215")
+	for _, apex := range newDomains {
+		if !containsDomain(n.OwnDomains, apex) {
+			n.OwnDomains = append(n.OwnDomains, apex)
+			n.Logger.Infow("Added domain",
+				"domain", apex)
+		}
+	}
+	for _, rr := range records {
+		if containsRR(n.loadedRecords, rr) {
+			continue
+		}
+		apex := touch(rr)
+		if rr.Header().Rrtype == dns.TypeSOA {
+			deleted[apex] = append(deleted[apex], n.removeRRs(rr.Header().Name, isType(dns.TypeSOA))...)
+		}
+		if n.insertRR(rr) {
+			added[apex] = append(added[apex], rr)
+		}
+	}
+	n.loadedRecords = records
+
+	for _, apex := range n.OwnDomains {
+		// Fill in the SOA and NS records of the new zones, and of the zones that lost them with a removed zone file
+		n.addZone(apex, serial)
+		if n.Config.NS.DNSSEC && n.keys[apex] == nil {
+			n.loadKey(apex)
+		}
+	}
+	for apex, old := range changed {
+		if len(added[apex]) > 0 || len(deleted[apex]) > 0 {
+			n.zoneChanged(apex, old, added[apex], deleted[apex])
+		}
+	}
+	return nil
+}
+
+// containsRR compares the records including the TTL, so that a changed TTL is applied as well
+func containsRR(rrs []dns.RR, rr dns.RR) bool {
+	for _, r := range rrs {
+		if r.String() == rr.String() {
+			return true
+		}
+	}
+	return false
+}
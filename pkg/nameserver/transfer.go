@@ -0,0 +1,205 @@
+package nameserver
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// acceptMessage accepts the dynamic UPDATE messages in addition to the messages accepted by default
+func acceptMessage(dh dns.Header) dns.MsgAcceptAction {
+	opcode := int(dh.Bits>>11) & 0xF
+	if opcode == dns.OpcodeUpdate && dh.Bits&(1<<15) == 0 {
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// handleTransfer answers AXFR and IXFR requests from the allowed addresses or with a valid TSIG signature
+func (n *Nameserver) handleTransfer(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	apex := strings.ToLower(q.Name)
+	m := new(dns.Msg)
+	m.SetReply(r)
+	n.mu.RLock()
+	managed := containsDomain(n.OwnDomains, apex)
+	allowed := n.transferAllowed(w, r)
+	n.mu.RUnlock()
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	switch {
+	case !allowed:
+		m.Rcode = dns.RcodeRefused
+	case !managed:
+		m.Rcode = dns.RcodeNotAuth
+	case udp && q.Qtype == dns.TypeAXFR:
+		m.Rcode = dns.RcodeFormatError
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		n.recordZoneRequest(w, r, m.Rcode)
+		_ = w.WriteMsg(m)
+		return
+	}
+	n.mu.RLock()
+	rrs := n.transferRecords(apex, r)
+	n.mu.RUnlock()
+	if len(rrs) == 0 {
+		m.Rcode = dns.RcodeServerFailure
+		n.recordZoneRequest(w, r, m.Rcode)
+		_ = w.WriteMsg(m)
+		return
+	}
+	n.recordZoneRequest(w, r, dns.RcodeSuccess)
+	if udp {
+		// Answer with only the SOA record over UDP, a client that is behind retries over TCP
+		m.Authoritative = true
+		m.Answer = rrs[:1]
+		if tsig := r.IsTsig(); tsig != nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		_ = w.WriteMsg(m)
+		return
+	}
+	tr := new(dns.Transfer)
+	ch := make(chan *dns.Envelope)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tr.Out(w, r, ch)
+	}()
+	// Send the records in batches to keep the messages under the maximum size
+	for len(rrs) > 0 {
+		batch := rrs
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		ch <- &dns.Envelope{RR: batch}
+		rrs = rrs[len(batch):]
+	}
+	close(ch)
+	if err := <-errc; err != nil {
+		n.Logger.Errorw("Error while sending zone transfer",
+			"domain", apex,
+			"remoteAddr", w.RemoteAddr().String(),
+			"error", err)
+	}
+}
+
+func (n *Nameserver) transferAllowed(w dns.ResponseWriter, r *dns.Msg) bool {
+	if r.IsTsig() != nil {
+		return w.TsigStatus() == nil
+	}
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range n.Config.NS.AllowTransfer.ValidEntries() {
+		_, cidr, err := net.ParseCIDR(v)
+		if err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// transferRecords returns the records for the AXFR or IXFR response, starting and ending with the current SOA record.
+// IXFR is answered incrementally if the journal reaches back to the serial of the client, and with the full zone otherwise.
+func (n *Nameserver) transferRecords(apex string, r *dns.Msg) []dns.RR {
+	soa := n.zoneSOACopy(apex)
+	if soa == nil {
+		return nil
+	}
+	if r.Question[0].Qtype == dns.TypeIXFR && len(r.Ns) > 0 {
+		if client, ok := r.Ns[0].(*dns.SOA); ok {
+			if !serialNewer(soa.Serial, client.Serial) {
+				return []dns.RR{soa}
+			}
+			if diffs, ok := n.journalSince(apex, client.Serial); ok {
+				rrs := []dns.RR{soa}
+				for _, diff := range diffs {
+					rrs = append(rrs, diff.from)
+					rrs = append(rrs, diff.deleted...)
+					rrs = append(rrs, diff.to)
+					rrs = append(rrs, diff.added...)
+				}
+				return append(rrs, soa)
+			}
+		}
+	}
+	rrs := []dns.RR{soa}
+	for name, recs := range n.Domains {
+		if n.zoneApex(name) != apex {
+			continue
+		}
+		for _, rr := range recs.Records {
+			switch rr.Header().Rrtype {
+			case dns.TypeSOA, dns.TypeDNSKEY:
+				// DNSSEC signatures are created on the fly, so the key is of no use for the secondaries
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+	rrs = append(rrs, n.synthesizedWildcards(apex)...)
+	return append(rrs, soa)
+}
+
+// synthesizedWildcards returns wildcard records approximating the synthesized answers of the zone for the secondaries.
+// The UUID CNAME answers can't be expressed as records and are left out.
+func (n *Nameserver) synthesizedWildcards(apex string) []dns.RR {
+	rrs := []dns.RR{}
+	p := n.profileFor(apex)
+	if p.Passthrough {
+		return rrs
+	}
+	wildcard := "*." + apex
+	if p.synthesizes(dns.TypeA) {
+		rrs = append(rrs, n.dynamicA(wildcard, p.A, uint32(n.Config.NS.Ttl))...)
+	}
+	if p.synthesizes(dns.TypeAAAA) {
+		rrs = append(rrs, n.dynamicAAAA(wildcard, p.AAAA, uint32(n.Config.NS.Ttl))...)
+	}
+	if p.synthesizes(dns.TypeMX) {
+		mx := new(dns.MX)
+		mx.Hdr = dns.RR_Header{Name: wildcard, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: uint32(n.Config.NS.Ttl)}
+		mx.Mx = dns.Fqdn(p.MX)
+		mx.Preference = 10
+		rrs = append(rrs, mx)
+	}
+	return rrs
+}
+
+// recordZoneRequest stores the zone transfer and update requests as events
+func (n *Nameserver) recordZoneRequest(w dns.ResponseWriter, r *dns.Msg, rcode int) {
+	remoteAddr := w.RemoteAddr().String()
+	event := certainly.NewEvent("dns", remoteAddr)
+	if len(r.Question) > 0 {
+		event.ServerName = r.Question[0].Name
+		event.Data["qtype"] = dns.TypeToString[r.Question[0].Qtype]
+	}
+	event.Data["opcode"] = dns.OpcodeToString[r.Opcode]
+	event.Data["rcode"] = dns.RcodeToString[rcode]
+	event.Data["transport"] = w.RemoteAddr().Network()
+	event.Data["family"] = addrFamily(remoteAddr)
+	if tsig := r.IsTsig(); tsig != nil {
+		event.Data["tsig"] = tsig.Hdr.Name
+	}
+	n.Events.Record(event)
+	n.Logger.Infow("Zone request",
+		"opcode", event.Data["opcode"],
+		"qtype", event.Data["qtype"],
+		"domain", event.ServerName,
+		"rcode", event.Data["rcode"],
+		"remoteAddr", remoteAddr,
+		"tsig", event.Data["tsig"],
+		"session", event.SessionID)
+}
@@ -0,0 +1,109 @@
+package nameserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// transfer requests an IXFR of the zone from the serial, signing it with the test key if signed is set
+func transfer(addr, zone string, serial uint32, signed bool) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetIxfr(zone, serial, "ns.mom.tld.", "admin.mom.tld.")
+	tr := &dns.Transfer{}
+	if signed {
+		tr.TsigSecret = map[string]string{testKeyName: testSecret}
+		m.SetTsig(testKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	envs, err := tr.In(m, addr)
+	if err != nil {
+		return nil, err
+	}
+	rrs := []dns.RR{}
+	for env := range envs {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	return rrs, nil
+}
+
+// summarize lists the SOA serials relative to base and the names of the other records
+func summarize(rrs []dns.RR, base uint32) string {
+	parts := []string{}
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			parts = append(parts, fmt.Sprintf("SOA+%d", soa.Serial-base))
+			continue
+		}
+		parts = append(parts, rr.Header().Name)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestIncrementalTransfer(t *testing.T) {
+	config := updateConfig(serve(t, dns.HandlerFunc(stubUpstream)))
+	config.NS.Proto = "tcp"
+	n, addr := startNameserver(t, config)
+	n.mu.RLock()
+	start := n.zoneSOA("coogle.com.").Serial
+	n.mu.RUnlock()
+	for _, change := range []func() error{
+		func() error { return n.AddRecord("a.coogle.com. 60 IN A 192.0.2.1") },
+		func() error { return n.AddRecord("b.coogle.com. 60 IN A 192.0.2.2") },
+		func() error { return n.DeleteRecord("a.coogle.com. 60 IN A 192.0.2.1") },
+	} {
+		if err := change(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := transfer(addr, "coogle.com.", start, false); err == nil || !strings.HasSuffix(err.Error(), fmt.Sprintf("rcode: %d", dns.RcodeRefused)) {
+		t.Errorf("unsigned transfer error = %v, want REFUSED", err)
+	}
+	// The transfers from the allowed addresses don't have to be signed
+	n.mu.Lock()
+	n.Config.NS.AllowTransfer = []string{"127.0.0.0/8"}
+	n.mu.Unlock()
+	if rrs, err := transfer(addr, "coogle.com.", start+3, false); err != nil || summarize(rrs, start) != "SOA+3" {
+		t.Errorf("unsigned transfer from an allowed address = %s, %v", summarize(rrs, start), err)
+	}
+
+	tests := []struct {
+		from uint32
+		want string
+	}{
+		// The journal has every change since the serial of the client
+		{start, "SOA+3 SOA+0 SOA+1 a.coogle.com. SOA+1 SOA+2 b.coogle.com. SOA+2 a.coogle.com. SOA+3 SOA+3"},
+		{start + 2, "SOA+3 SOA+2 a.coogle.com. SOA+3 SOA+3"},
+		// A client that is up to date only gets the current SOA
+		{start + 3, "SOA+3"},
+	}
+	for _, test := range tests {
+		rrs, err := transfer(addr, "coogle.com.", test.from, true)
+		if err != nil {
+			t.Errorf("IXFR from %d: %s", test.from, err)
+			continue
+		}
+		if got := summarize(rrs, start); got != test.want {
+			t.Errorf("IXFR from %d = %s, want %s", test.from, got, test.want)
+		}
+	}
+
+	// The journal doesn't reach back to an unknown serial, so the whole zone is transferred
+	rrs, err := transfer(addr, "coogle.com.", start-10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(rrs, start)
+	if !strings.HasPrefix(got, "SOA+3 ") || !strings.HasSuffix(got, " SOA+3") || strings.Count(got, "SOA") != 2 {
+		t.Errorf("IXFR from an unknown serial = %s, want the whole zone", got)
+	}
+	if !strings.Contains(got, " b.coogle.com. ") || strings.Contains(got, " a.coogle.com. ") {
+		t.Errorf("IXFR from an unknown serial = %s, want b.coogle.com without a.coogle.com", got)
+	}
+}
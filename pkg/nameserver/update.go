@@ -0,0 +1,223 @@
+package nameserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// handleUpdate processes RFC 2136 dynamic UPDATE messages. Only messages signed with one of the configured TSIG keys are accepted.
+func (n *Nameserver) handleUpdate(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	tsig := r.IsTsig()
+	switch {
+	case tsig == nil:
+		m.Rcode = dns.RcodeRefused
+	case w.TsigStatus() != nil:
+		m.Rcode = dns.RcodeNotAuth
+	default:
+		n.mu.Lock()
+		m.Rcode = n.update(r)
+		n.mu.Unlock()
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	n.recordZoneRequest(w, r, m.Rcode)
+	_ = w.WriteMsg(m)
+}
+
+// update checks the prerequisites and applies the changes of the UPDATE message, returning the response code
+func (n *Nameserver) update(r *dns.Msg) int {
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+	apex := strings.ToLower(dns.Fqdn(r.Question[0].Name))
+	if !containsDomain(n.OwnDomains, apex) {
+		return dns.RcodeNotAuth
+	}
+	if rcode := n.checkPrerequisites(apex, r.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if rcode := prescanUpdates(apex, r.Ns); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	old := n.zoneSOACopy(apex)
+	added := []dns.RR{}
+	deleted := []dns.RR{}
+	for _, rr := range r.Ns {
+		name := strings.ToLower(rr.Header().Name)
+		rrtype := rr.Header().Rrtype
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			if rrtype == dns.TypeSOA {
+				// The SOA record can only be replaced with a newer one
+				soa, ok := rr.(*dns.SOA)
+				if name != apex || !ok || old == nil || !serialNewer(soa.Serial, n.zoneSOA(apex).Serial) {
+					continue
+				}
+				deleted = append(deleted, n.removeRRs(name, isType(dns.TypeSOA))...)
+			}
+			if n.insertRR(rr) {
+				added = append(added, rr)
+			}
+		case dns.ClassANY:
+			deleted = append(deleted, n.removeRRs(name, func(existing dns.RR) bool {
+				t := existing.Header().Rrtype
+				if name == apex && (t == dns.TypeSOA || t == dns.TypeNS) {
+					// The apex SOA and NS records can't be removed
+					return false
+				}
+				return rrtype == dns.TypeANY || t == rrtype
+			})...)
+		case dns.ClassNONE:
+			if rrtype == dns.TypeSOA || (name == apex && rrtype == dns.TypeNS && len(n.zoneNS(apex)) <= 1) {
+				continue
+			}
+			target := dns.Copy(rr)
+			target.Header().Class = dns.ClassINET
+			deleted = append(deleted, n.removeRRs(name, func(existing dns.RR) bool {
+				return dns.IsDuplicate(existing, target)
+			})...)
+		}
+	}
+	if len(added) > 0 || len(deleted) > 0 {
+		n.zoneChanged(apex, old, added, deleted)
+	}
+	return dns.RcodeSuccess
+}
+
+// checkPrerequisites checks the prerequisite section of an UPDATE message as defined in RFC 2136 section 3.2
+func (n *Nameserver) checkPrerequisites(apex string, prereqs []dns.RR) int {
+	// Value dependent prerequisites are compared as whole RRsets
+	rrsets := map[rrsetKey][]dns.RR{}
+	for _, rr := range prereqs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if !util.InDomain(name, apex) {
+			return dns.RcodeNotZone
+		}
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(n.Domains[name].Records) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if len(n.rrset(name, h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(n.Domains[name].Records) > 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if len(n.rrset(name, h.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := rrsetKey{name, h.Rrtype}
+			rrsets[key] = append(rrsets[key], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	for key, expected := range rrsets {
+		if !sameRRset(n.rrset(key.name, key.rrtype), expected) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// prescanUpdates checks the update section of an UPDATE message as defined in RFC 2136 section 3.4.1
+func prescanUpdates(apex string, updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !util.InDomain(h.Name, apex) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if isMetaType(h.Rrtype) || h.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || (isMetaType(h.Rrtype) && h.Rrtype != dns.TypeANY) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || isMetaType(h.Rrtype) || h.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// rrset returns the static records of the name with the given type
+func (n *Nameserver) rrset(name string, rrtype uint16) []dns.RR {
+	rrs := []dns.RR{}
+	for _, rr := range n.Domains[name].Records {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// sameRRset compares the RRsets ignoring the TTLs, the order and the class of the expected records
+func sameRRset(existing, expected []dns.RR) bool {
+	if len(existing) != len(expected) {
+		return false
+	}
+	for _, e := range expected {
+		e = dns.Copy(e)
+		e.Header().Name = strings.ToLower(e.Header().Name)
+		e.Header().Class = dns.ClassINET
+		found := false
+		for _, rr := range existing {
+			if dns.IsDuplicate(rr, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func isType(rrtype uint16) func(dns.RR) bool {
+	return func(rr dns.RR) bool {
+		return rr.Header().Rrtype == rrtype
+	}
+}
+
+func isMetaType(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	}
+	return false
+}
+
+// serialNewer compares the SOA serials using the serial number arithmetic of RFC 1982
+func serialNewer(serial, than uint32) bool {
+	return serial != than && serial-than < 1<<31
+}
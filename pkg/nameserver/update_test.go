@@ -0,0 +1,105 @@
+package nameserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+const (
+	testKeyName = "update.mom.tld."
+	testSecret  = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+func updateConfig(upstream string) *certainly.CertainlyCFG {
+	config := testConfig(upstream)
+	// The configured key names are looked up in the canonical form
+	config.NS.TSIGKeys = map[string]string{"Update.mom.tld": testSecret}
+	return config
+}
+
+// sendUpdate sends the UPDATE message, signing it with the key if the key name is set
+func sendUpdate(t *testing.T, addr string, m *dns.Msg, keyName, secret string) *dns.Msg {
+	t.Helper()
+	c := &dns.Client{Timeout: 2 * time.Second}
+	if keyName != "" {
+		c.TsigSecret = map[string]string{keyName: secret}
+		m.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	in, _, err := c.Exchange(m, addr)
+	// The client reports the signed NOTAUTH responses as an authentication error
+	if err != nil && (!errors.Is(err, dns.ErrAuth) || in == nil) {
+		t.Fatalf("update %s: %s", m.Question[0].Name, err)
+	}
+	return in
+}
+
+func newRRs(t *testing.T, records ...string) []dns.RR {
+	t.Helper()
+	rrs := []dns.RR{}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func TestUpdate(t *testing.T) {
+	_, addr := startNameserver(t, updateConfig(serve(t, dns.HandlerFunc(stubUpstream))))
+	serial := soaSerial(query(t, addr, "coogle.com.", dns.TypeSOA))
+	if serial == 0 {
+		t.Fatal("no SOA record for coogle.com")
+	}
+	update := func(zone string, insert, remove, used []string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate(zone)
+		m.Insert(newRRs(t, insert...))
+		m.Remove(newRRs(t, remove...))
+		m.NameUsed(newRRs(t, used...))
+		return m
+	}
+	record := "upd.coogle.com. 60 IN A 192.0.2.20"
+	tests := []struct {
+		name    string
+		msg     *dns.Msg
+		keyName string
+		secret  string
+		rcode   int
+		bumped  bool
+		present bool
+	}{
+		{"unsigned", update("coogle.com.", []string{record}, nil, nil), "", "", dns.RcodeRefused, false, false},
+		{"wrong secret", update("coogle.com.", []string{record}, nil, nil), testKeyName, "d3JvbmdzZWNyZXR3cm9uZ3NlY3JldA==", dns.RcodeNotAuth, false, false},
+		{"unknown key", update("coogle.com.", []string{record}, nil, nil), "other.mom.tld.", testSecret, dns.RcodeNotAuth, false, false},
+		{"unmanaged zone", update("google.com.", []string{"www.google.com. 60 IN A 192.0.2.66"}, nil, nil), testKeyName, testSecret, dns.RcodeNotAuth, false, false},
+		{"outside of the zone", update("coogle.com.", []string{"www.woogle.com. 60 IN A 192.0.2.66"}, nil, nil), testKeyName, testSecret, dns.RcodeNotZone, false, false},
+		{"failed prerequisite", update("coogle.com.", []string{record}, nil, []string{"missing.coogle.com. 60 IN A 192.0.2.1"}), testKeyName, testSecret, dns.RcodeNameError, false, false},
+		{"insert", update("coogle.com.", []string{record}, nil, nil), testKeyName, testSecret, dns.RcodeSuccess, true, true},
+		{"insert again", update("COOGLE.com.", []string{record}, nil, nil), testKeyName, testSecret, dns.RcodeSuccess, false, true},
+		{"remove", update("coogle.com.", nil, []string{record}, nil), testKeyName, testSecret, dns.RcodeSuccess, true, false},
+	}
+	for _, test := range tests {
+		in := sendUpdate(t, addr, test.msg, test.keyName, test.secret)
+		if in.Rcode != test.rcode {
+			t.Errorf("%s: %s, want %s", test.name, dns.RcodeToString[in.Rcode], dns.RcodeToString[test.rcode])
+		}
+		if test.bumped {
+			serial++
+		}
+		if got := soaSerial(query(t, addr, "coogle.com.", dns.TypeSOA)); got != serial {
+			t.Errorf("%s: serial %d, want %d", test.name, got, serial)
+		}
+		// The name is answered with the synthesized address in any case
+		if got := addrs(query(t, addr, "upd.coogle.com.", dns.TypeA)); strings.Contains(strings.Join(got, ","), "192.0.2.20") != test.present {
+			t.Errorf("%s: upd.coogle.com answered %v, want the updated record %t", test.name, got, test.present)
+		}
+	}
+}
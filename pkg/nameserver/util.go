@@ -1,6 +1,11 @@
 package nameserver
 
-import "strings"
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
 
 func sanitizeDomainQuestion(d string) string {
 	dom := strings.ToLower(d)
@@ -10,3 +15,37 @@ func sanitizeDomainQuestion(d string) string {
 	}
 	return dom
 }
+
+// addrFamily returns the IP version of the remote address, "ipv4" or "ipv6"
+func addrFamily(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "unknown"
+	}
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// tsigSecrets returns the TSIG secrets with the key names in the canonical form used for looking them up
+func tsigSecrets(keys map[string]string) map[string]string {
+	secrets := make(map[string]string)
+	for name, secret := range keys {
+		secrets[dns.CanonicalName(name)] = secret
+	}
+	return secrets
+}
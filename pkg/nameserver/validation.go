@@ -11,6 +11,8 @@ func (n *Nameserver) SetChallengeToken(domain, key string) {
 	if !strings.HasSuffix(domain, ".") {
 		domain = domain + "."
 	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	n.ownChallenges[domain] = key
 }
 
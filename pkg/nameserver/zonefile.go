@@ -0,0 +1,39 @@
+package nameserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// zoneFileRecords reads the records from the configured RFC 1035 zone files. A file with errors is skipped as a whole
+// to avoid serving a partial zone.
+func (n *Nameserver) zoneFileRecords(config *certainly.CertainlyCFG) ([]dns.RR, []error) {
+	records := []dns.RR{}
+	errs := []error{}
+	domains := make([]string, 0, len(config.NS.ZoneFiles))
+	for d := range config.NS.ZoneFiles {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	for _, d := range domains {
+		origin := strings.ToLower(dns.Fqdn(d))
+		for _, filename := range config.NS.ZoneFiles[d] {
+			rrs, err := certainly.ParseZoneFile(filename, origin)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("zone file for %s: %w", origin, err))
+				continue
+			}
+			records = append(records, rrs...)
+			n.Logger.Infow("Loaded zone file",
+				"domain", origin,
+				"file", filename,
+				"records", len(rrs))
+		}
+	}
+	return records, errs
+}
@@ -0,0 +1,106 @@
+package nameserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// zoneTTL is the TTL of the SOA and NS records of the zones
+const zoneTTL = 3600
+
+// addZones adds the SOA record, and the NS record unless there's one in the static records, for every managed apex domain.
+// A SOA record from the static records or the zone files is used as is.
+func (n *Nameserver) addZones() {
+	// Create serial
+	serial := time.Now().Format("2006010215")
+	for _, apex := range n.OwnDomains {
+		n.addZone(apex, serial)
+	}
+}
+
+func (n *Nameserver) addZone(apex, serial string) {
+	if n.zoneSOA(apex) == nil {
+		soa, err := dns.NewRR(fmt.Sprintf("%s %d SOA %s. %s. %s 28800 7200 604800 %d", apex, zoneTTL, strings.ToLower(n.Config.NS.Nsname), strings.ToLower(n.Config.NS.Nsadmin), serial, n.Config.NS.Ttl))
+		if err != nil {
+			n.Logger.Errorw("Error while adding SOA record",
+				"error", err.Error(),
+				"domain", apex)
+			return
+		}
+		n.appendRR(soa)
+	}
+	if strings.EqualFold(apex, dns.Fqdn(n.Config.NS.DefaultDomain)) {
+		n.SOA = n.zoneSOA(apex)
+	}
+	if len(n.zoneNS(apex)) == 0 {
+		ns := new(dns.NS)
+		ns.Hdr = dns.RR_Header{Name: apex, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: zoneTTL}
+		ns.Ns = dns.Fqdn(strings.ToLower(n.Config.NS.Nsname))
+		n.appendRR(ns)
+	}
+}
+
+// zoneApex returns the longest managed apex domain for the name
+func (n *Nameserver) zoneApex(name string) string {
+	apex := ""
+	for _, d := range n.OwnDomains {
+		if util.InDomain(name, d) && len(d) > len(apex) {
+			apex = d
+		}
+	}
+	return apex
+}
+
+func (n *Nameserver) zoneSOA(apex string) *dns.SOA {
+	for _, rr := range n.Domains[apex].Records {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa
+		}
+	}
+	return nil
+}
+
+func (n *Nameserver) zoneNS(apex string) []dns.RR {
+	ns := []dns.RR{}
+	for _, rr := range n.Domains[apex].Records {
+		if rr.Header().Rrtype == dns.TypeNS {
+			ns = append(ns, rr)
+		}
+	}
+	return ns
+}
+
+// negativeSOA returns the SOA record for negative answers with the TTL set to the lower of the SOA TTL and the SOA minimum field
+func (n *Nameserver) negativeSOA(apex string) dns.RR {
+	soa := n.zoneSOA(apex)
+	if soa == nil {
+		return nil
+	}
+	neg := dns.Copy(soa).(*dns.SOA)
+	if neg.Minttl < neg.Hdr.Ttl {
+		neg.Hdr.Ttl = neg.Minttl
+	}
+	return neg
+}
+
+// glue returns the static A and AAAA records of the nameserver names in the NS records
+func (n *Nameserver) glue(nsRecords []dns.RR) []dns.RR {
+	glue := []dns.RR{}
+	for _, rr := range nsRecords {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		for _, addr := range n.Domains[strings.ToLower(ns.Ns)].Records {
+			if addr.Header().Rrtype == dns.TypeA || addr.Header().Rrtype == dns.TypeAAAA {
+				glue = append(glue, addr)
+			}
+		}
+	}
+	return glue
+}
@@ -1,47 +1,123 @@
 package notification
 
 import (
-	"regexp"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
 	"go.uber.org/zap"
 )
 
+// queueSize is the number of notifications waiting to be sent before new ones are dropped
+const queueSize = 1000
+
 type Notifications struct {
 	Engines []certainly.Notification
 	Config  *certainly.CertainlyCFG
 	Logger  *zap.SugaredLogger
+	// The notifications are sent in the background so that a slow engine doesn't hold up the servers
+	queue chan message
+	done  chan struct{}
+	// mu guards the engines and the config, which are replaced when the configuration is reloaded
+	mu     sync.Mutex
+	closed bool
+}
+
+type message struct {
+	protocol string
+	text     string
 }
 
 func Initialize(config *certainly.CertainlyCFG, logger *zap.SugaredLogger) *Notifications {
 	notifications := &Notifications{Config: config, Logger: logger}
-	notifications.Engines = make([]certainly.Notification, 0)
+	notifications.queue = make(chan message, queueSize)
+	notifications.done = make(chan struct{})
+	engines, err := newEngines(config, logger)
+	if err != nil {
+		logger.Errorw("Failed to initialize the notification engines",
+			"error", err)
+	}
+	notifications.Engines = engines
+	go notifications.send()
+	return notifications
+}
+
+// newEngines creates the configured notification engines, returning an error if any of them can't be initialized
+func newEngines(config *certainly.CertainlyCFG, logger *zap.SugaredLogger) ([]certainly.Notification, error) {
+	engines := make([]certainly.Notification, 0)
 	if config.Notification.Slack {
 		slack, err := NewSlack(config, logger)
 		if err != nil {
-			logger.Errorf("Failed to initialize Slack notification engine",
-				"error", err)
-		} else {
-			notifications.Engines = append(notifications.Engines, slack)
+			return engines, fmt.Errorf("slack notifications: %w", err)
 		}
+		engines = append(engines, slack)
 	}
-	return notifications
+	return engines, nil
 }
 
-func (n *Notifications) Notify(protocol string, message string) {
-	for _, engine := range n.Engines {
-		engine.Notify(protocol, message)
+// Reload recreates the notification engines if the notification settings have changed. If the new engines can't be
+// initialized, the running engines and configuration are kept and the error is returned.
+func (n *Notifications) Reload(config *certainly.CertainlyCFG) error {
+	n.mu.Lock()
+	changed := !reflect.DeepEqual(n.Config.Notification, config.Notification)
+	n.mu.Unlock()
+	var engines []certainly.Notification
+	if changed {
+		var err error
+		if engines, err = newEngines(config, n.Logger); err != nil {
+			return err
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.Config = config
+	if changed {
+		n.Engines = engines
 	}
+	return nil
 }
 
-func matchAnyFilter(data string, filters []string) bool {
-	for _, filter := range filters {
-		if filter == "" {
-			continue
-		}
-		if match, _ := regexp.MatchString(filter, data); match {
-			return true
+// Notify queues the message to be sent with all the notification engines
+func (n *Notifications) Notify(protocol string, text string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed || len(n.Engines) == 0 {
+		return
+	}
+	select {
+	case n.queue <- message{protocol, text}:
+	default:
+		n.Logger.Errorw("Notification queue is full, dropping notification",
+			"protocol", protocol)
+	}
+}
+
+func (n *Notifications) send() {
+	defer close(n.done)
+	for msg := range n.queue {
+		n.mu.Lock()
+		engines := n.Engines
+		n.mu.Unlock()
+		for _, engine := range engines {
+			engine.Notify(msg.protocol, msg.text)
 		}
 	}
-	return false
+}
+
+// Close stops accepting new notifications and waits for the queued ones to be sent until the context is done
+func (n *Notifications) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+type recordingEngine struct {
+	messages []string
+}
+
+func (e *recordingEngine) Notify(protocol string, message string) {
+	e.messages = append(e.messages, message)
+}
+
+func TestReloadKeepsEnginesOnError(t *testing.T) {
+	config := &certainly.CertainlyCFG{}
+	n := Initialize(config, zap.NewNop().Sugar())
+	defer n.Close(context.Background()) //nolint:all
+	engine := &recordingEngine{}
+	n.Engines = []certainly.Notification{engine}
+
+	// Slack without a token can't be initialized
+	broken := &certainly.CertainlyCFG{}
+	broken.Notification.Slack = true
+	if err := n.Reload(broken); err == nil {
+		t.Fatal("Reload succeeded with a broken Slack configuration")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.Config != config {
+		t.Error("Reload switched to the broken configuration")
+	}
+	if len(n.Engines) != 1 || n.Engines[0] != engine {
+		t.Errorf("Reload replaced the running engines with %v", n.Engines)
+	}
+}
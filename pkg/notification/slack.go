@@ -9,6 +9,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/rules"
 )
 
 var (
@@ -20,16 +21,16 @@ type Slack struct {
 	SlackDefaultChannel string
 	SlackHTTP           bool
 	SlackHTTPChannel    string
-	SlackHTTPFilters    []string
+	SlackHTTPFilters    *rules.Set
 	SlackDNS            bool
 	SlackDNSChannel     string
-	SlackDNSFilters     []string
+	SlackDNSFilters     *rules.Set
 	SlackSMTP           bool
 	SlackSMTPChannel    string
-	SlackSMTPFilters    []string
+	SlackSMTPFilters    *rules.Set
 	SlackIMAP           bool
 	SlackIMAPChannel    string
-	SlackIMAPFilters    []string
+	SlackIMAPFilters    *rules.Set
 	Client              *slack.Client
 	Logger              *zap.SugaredLogger
 }
@@ -66,14 +67,26 @@ func NewSlack(config *certainly.CertainlyCFG, logger *zap.SugaredLogger) (*Slack
 	} else {
 		s.SlackIMAPChannel = s.SlackDefaultChannel
 	}
-	s.SlackDNSFilters, s.SlackHTTPFilters, s.SlackSMTPFilters, s.SlackIMAPFilters = config.Notification.DNSFilters, config.Notification.HTTPFilters, config.Notification.SMTPFilters, config.Notification.IMAPFilters
+	var err error
+	if s.SlackDNSFilters, err = rules.Compile(rules.FromPatterns("notification.dns_filters", config.Notification.DNSFilters)); err != nil {
+		return s, err
+	}
+	if s.SlackHTTPFilters, err = rules.Compile(rules.FromPatterns("notification.http_filters", config.Notification.HTTPFilters)); err != nil {
+		return s, err
+	}
+	if s.SlackSMTPFilters, err = rules.Compile(rules.FromPatterns("notification.smtp_filters", config.Notification.SMTPFilters)); err != nil {
+		return s, err
+	}
+	if s.SlackIMAPFilters, err = rules.Compile(rules.FromPatterns("notification.imap_filters", config.Notification.IMAPFilters)); err != nil {
+		return s, err
+	}
 	s.SlackHTTP = config.Notification.HTTP
 	s.SlackDNS = config.Notification.DNS
 	s.SlackSMTP = config.Notification.SMTP
 	s.SlackIMAP = config.Notification.IMAP
 	s.Client = slack.New(s.SlackToken)
 	// Test slack auth and connection
-	_, err := s.Client.AuthTest()
+	_, err = s.Client.AuthTest()
 	if err != nil {
 		return s, fmt.Errorf("failed to authenticate with Slack: %s", err)
 	}
@@ -87,7 +100,7 @@ func (s *Slack) Notify(protocol string, message string) {
 		if !s.SlackDNS {
 			return
 		}
-		if matchAnyFilter(message, s.SlackDNSFilters) {
+		if s.filtered(protocol, message, s.SlackDNSFilters) {
 			return
 		}
 		channel = s.SlackDNSChannel
@@ -96,7 +109,7 @@ func (s *Slack) Notify(protocol string, message string) {
 		if !s.SlackHTTP {
 			return
 		}
-		if matchAnyFilter(message, s.SlackHTTPFilters) {
+		if s.filtered(protocol, message, s.SlackHTTPFilters) {
 			return
 		}
 		channel = s.SlackHTTPChannel
@@ -105,7 +118,7 @@ func (s *Slack) Notify(protocol string, message string) {
 		if !s.SlackSMTP {
 			return
 		}
-		if matchAnyFilter(message, s.SlackSMTPFilters) {
+		if s.filtered(protocol, message, s.SlackSMTPFilters) {
 			return
 		}
 		channel = s.SlackSMTPChannel
@@ -114,7 +127,7 @@ func (s *Slack) Notify(protocol string, message string) {
 		if !s.SlackIMAP {
 			return
 		}
-		if matchAnyFilter(message, s.SlackIMAPFilters) {
+		if s.filtered(protocol, message, s.SlackIMAPFilters) {
 			return
 		}
 		channel = s.SlackIMAPChannel
@@ -126,6 +139,18 @@ func (s *Slack) Notify(protocol string, message string) {
 	}
 }
 
+// filtered returns true if the message matches one of the filters and shouldn't be sent
+func (s *Slack) filtered(protocol, message string, filters *rules.Set) bool {
+	filter := filters.Match(message)
+	if filter == nil {
+		return false
+	}
+	s.Logger.Debugw("Notification filtered",
+		"protocol", protocol,
+		"filter", filter.Name)
+	return true
+}
+
 func formatSlackMessage(message string) string {
 	message = strings.ReplaceAll(message, "```", "` ` `")
 	t := time.Now()
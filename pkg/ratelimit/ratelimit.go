@@ -0,0 +1,314 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/notification"
+	"github.com/happycakefriends/certainly/pkg/util"
+)
+
+// Action is the decision for a single query
+type Action int
+
+const (
+	// Allow answers the query normally
+	Allow Action = iota
+	// Drop leaves the query unanswered
+	Drop
+	// Slip answers with an empty truncated response, so that a real client behind the prefix retries over TCP
+	Slip
+)
+
+// maxEntries bounds the number of tracked buckets. When a flood of spoofed sources fills the table,
+// the queries that would need a new bucket are dropped until the idle buckets expire.
+const maxEntries = 200000
+
+// logSummaryEntries is the number of the most limited sources listed in the periodic log summary
+const logSummaryEntries = 10
+
+// Stats are the counters of the rate limiter since the start
+type Stats struct {
+	Enabled bool   `json:"enabled"`
+	Allowed uint64 `json:"allowed"`
+	Dropped uint64 `json:"dropped"`
+	Slipped uint64 `json:"slipped"`
+	// Tracked is the number of buckets currently tracked
+	Tracked int `json:"tracked"`
+}
+
+// Limiter is a token bucket rate limiter for the UDP queries, keyed by the source prefix and the question name and
+// type, and optionally by the source prefix alone. Suppressed queries are logged as a periodic summary.
+type Limiter struct {
+	Logger       *zap.SugaredLogger
+	Notification *notification.Notifications
+	// mu guards everything below, the settings are replaced when the configuration is reloaded
+	mu         sync.Mutex
+	settings   settings
+	buckets    map[string]*bucket
+	stats      Stats
+	suppressed map[string]*suppression
+}
+
+type settings struct {
+	enabled            bool
+	responsesPerSecond float64
+	allPerSecond       float64
+	window             time.Duration
+	slip               int
+	ipv4Mask           net.IPMask
+	ipv6Mask           net.IPMask
+	exempt             []*net.IPNet
+	logInterval        time.Duration
+}
+
+// bucket holds the balance of a single key. The balance grows by the rate every second up to a second's worth of
+// queries, and goes down by one for every query, down to the debt of the whole window.
+type bucket struct {
+	balance float64
+	last    time.Time
+	// limited counts the suppressed queries for the slip ratio
+	limited int
+}
+
+// suppression is an entry of the aggregated log of the suppressed queries
+type suppression struct {
+	prefix  string
+	qname   string
+	qtype   string
+	dropped int
+	slipped int
+}
+
+// New creates the limiter and starts the background cleanup and logging
+func New(config *certainly.CertainlyCFG, logger *zap.SugaredLogger, notification *notification.Notifications) *Limiter {
+	l := &Limiter{
+		Logger:       logger,
+		Notification: notification,
+		settings:     newSettings(config),
+		buckets:      make(map[string]*bucket),
+		suppressed:   make(map[string]*suppression),
+	}
+	go l.maintain()
+	return l
+}
+
+func newSettings(config *certainly.CertainlyCFG) settings {
+	c := config.RateLimit
+	s := settings{
+		enabled:            c.Enabled,
+		responsesPerSecond: float64(c.ResponsesPerSecond),
+		allPerSecond:       float64(c.AllPerSecond),
+		window:             time.Duration(c.Window) * time.Second,
+		slip:               c.Slip,
+		ipv4Mask:           net.CIDRMask(c.IPv4Prefix, 32),
+		ipv6Mask:           net.CIDRMask(c.IPv6Prefix, 128),
+		logInterval:        time.Duration(c.LogInterval) * time.Second,
+	}
+	for _, cidr := range c.Exempt.ValidEntries() {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			s.exempt = append(s.exempt, network)
+		}
+	}
+	return s
+}
+
+// Reload switches to the rate limits of the new configuration, keeping the current balances
+func (l *Limiter) Reload(config *certainly.CertainlyCFG) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = newSettings(config)
+	return nil
+}
+
+// Check decides whether a query from addr should be answered
+func (l *Limiter) Check(addr net.Addr, qname string, qtype uint16) Action {
+	ip := util.AddrIP(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.settings
+	if !s.enabled || ip == nil || s.isExempt(ip) {
+		l.stats.Allowed++
+		return Allow
+	}
+	prefix := s.prefix(ip)
+	name, rrtype := strings.ToLower(qname), dns.TypeToString[qtype]
+	now := time.Now()
+	limited := false
+	var limitedBy *bucket
+	if s.allPerSecond > 0 {
+		b := l.take(prefix, s.allPerSecond, s.window, now)
+		if b == nil || b.balance < 0 {
+			// The whole prefix is over its budget, so the summary doesn't list the names separately
+			limited, limitedBy = true, b
+			name, rrtype = "", ""
+		}
+	}
+	if !limited && s.responsesPerSecond > 0 {
+		b := l.take(prefix+"|"+name+"|"+rrtype, s.responsesPerSecond, s.window, now)
+		if b == nil || b.balance < 0 {
+			limited, limitedBy = true, b
+		}
+	}
+	if !limited {
+		l.stats.Allowed++
+		return Allow
+	}
+	action := Drop
+	if limitedBy != nil && s.slip > 0 {
+		limitedBy.limited++
+		if limitedBy.limited%s.slip == 0 {
+			action = Slip
+		}
+	}
+	l.suppress(prefix, name, rrtype, action)
+	return action
+}
+
+// take charges a query to the bucket of the key, creating the bucket if needed. It returns nil if the table is full.
+func (l *Limiter) take(key string, rate float64, window time.Duration, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxEntries {
+			return nil
+		}
+		b = &bucket{balance: rate, last: now}
+		l.buckets[key] = b
+	}
+	b.balance += now.Sub(b.last).Seconds() * rate
+	if b.balance > rate {
+		b.balance = rate
+	}
+	b.last = now
+	b.balance--
+	if debt := -rate * window.Seconds(); b.balance < debt {
+		b.balance = debt
+	}
+	return b
+}
+
+func (l *Limiter) suppress(prefix, qname, qtype string, action Action) {
+	key := prefix + "|" + qname + "|" + qtype
+	sup, ok := l.suppressed[key]
+	if !ok {
+		if len(l.suppressed) >= maxEntries {
+			// Count the rest of a spoofed flood under a single entry
+			if sup, ok = l.suppressed[""]; !ok {
+				sup = &suppression{prefix: "other sources"}
+				l.suppressed[""] = sup
+			}
+		} else {
+			sup = &suppression{prefix: prefix, qname: qname, qtype: qtype}
+			l.suppressed[key] = sup
+		}
+	}
+	if action == Slip {
+		sup.slipped++
+		l.stats.Slipped++
+	} else {
+		sup.dropped++
+		l.stats.Dropped++
+	}
+}
+
+// Stats returns the counters of the limiter
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.stats
+	stats.Enabled = l.settings.enabled
+	stats.Tracked = len(l.buckets)
+	return stats
+}
+
+// maintain expires the idle buckets every second and logs the summary of the suppressed queries every log interval
+func (l *Limiter) maintain() {
+	lastLog := time.Now()
+	for now := range time.Tick(time.Second) {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			// An idle bucket has refilled long ago, so it's no different from a new one
+			if now.Sub(b.last) > l.settings.window+time.Second {
+				delete(l.buckets, key)
+			}
+		}
+		var summary []*suppression
+		if now.Sub(lastLog) >= l.settings.logInterval {
+			lastLog = now
+			for _, sup := range l.suppressed {
+				summary = append(summary, sup)
+			}
+			l.suppressed = make(map[string]*suppression)
+		}
+		l.mu.Unlock()
+		if len(summary) > 0 {
+			l.logSummary(summary)
+		}
+	}
+}
+
+// logSummary logs and notifies the suppressed queries of the last interval, listing the most limited sources
+func (l *Limiter) logSummary(summary []*suppression) {
+	sort.Slice(summary, func(i, j int) bool {
+		return summary[i].dropped+summary[i].slipped > summary[j].dropped+summary[j].slipped
+	})
+	dropped, slipped := 0, 0
+	for _, sup := range summary {
+		dropped += sup.dropped
+		slipped += sup.slipped
+	}
+	top := []string{}
+	for i, sup := range summary {
+		if i == logSummaryEntries {
+			break
+		}
+		top = append(top, sup.String())
+	}
+	l.Logger.Warnw("Rate limited DNS queries",
+		"dropped", dropped,
+		"slipped", slipped,
+		"sources", len(summary),
+		"top", top)
+	l.Notification.Notify("dns", fmt.Sprintf(`
+Rate limited DNS queries
+Dropped: %d
+Slipped: %d
+Sources: %d
+Top:
+%s`, dropped, slipped, len(summary), strings.Join(top, "\n")))
+}
+
+func (s *suppression) String() string {
+	target := "all queries"
+	if s.qname != "" {
+		target = s.qname + " " + s.qtype
+	}
+	return fmt.Sprintf("%s %s: %d dropped, %d slipped", s.prefix, target, s.dropped, s.slipped)
+}
+
+func (s settings) isExempt(ip net.IP) bool {
+	for _, network := range s.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefix returns the source network of the address in CIDR notation
+func (s settings) prefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ones, _ := s.ipv4Mask.Size()
+		return fmt.Sprintf("%s/%d", ip4.Mask(s.ipv4Mask), ones)
+	}
+	ones, _ := s.ipv6Mask.Size()
+	return fmt.Sprintf("%s/%d", ip.Mask(s.ipv6Mask), ones)
+}
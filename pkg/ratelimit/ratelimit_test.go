@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// newTestLimiter returns a limiter without the background maintenance, so that the tests control the buckets
+func newTestLimiter(configure func(config *certainly.CertainlyCFG)) *Limiter {
+	config := &certainly.CertainlyCFG{}
+	config.RateLimit.Enabled = true
+	config.RateLimit.ResponsesPerSecond = 1
+	config.RateLimit.Window = 15
+	config.RateLimit.IPv4Prefix = 24
+	config.RateLimit.IPv6Prefix = 56
+	if configure != nil {
+		configure(config)
+	}
+	return &Limiter{
+		Logger:     zap.NewNop().Sugar(),
+		settings:   newSettings(config),
+		buckets:    make(map[string]*bucket),
+		suppressed: make(map[string]*suppression),
+	}
+}
+
+func TestTake(t *testing.T) {
+	l := newTestLimiter(nil)
+	start := time.Now()
+	// A rate of 2 per second with a 3 second window allows a debt of 6 queries
+	steps := []struct {
+		offset  time.Duration
+		queries int
+		balance float64
+	}{
+		// A new bucket starts with a second's worth of queries
+		{0, 1, 1},
+		{0, 1, 0},
+		// The debt stops growing at the whole window
+		{0, 20, -6},
+		// The balance refills by the rate every second
+		{time.Second, 1, -5},
+		{1500 * time.Millisecond, 1, -5},
+		// But not over a second's worth of queries
+		{time.Minute, 1, 1},
+	}
+	for i, step := range steps {
+		var b *bucket
+		for q := 0; q < step.queries; q++ {
+			b = l.take("key", 2, 3*time.Second, start.Add(step.offset))
+		}
+		if b.balance != step.balance {
+			t.Errorf("step %d: balance %.2f, want %.2f", i, b.balance, step.balance)
+		}
+	}
+}
+
+func TestCheckSlip(t *testing.T) {
+	tests := []struct {
+		slip    int
+		actions []Action
+	}{
+		{0, []Action{Allow, Drop, Drop, Drop, Drop}},
+		{1, []Action{Allow, Slip, Slip, Slip, Slip}},
+		{2, []Action{Allow, Drop, Slip, Drop, Slip}},
+		{3, []Action{Allow, Drop, Drop, Slip, Drop}},
+	}
+	for _, test := range tests {
+		l := newTestLimiter(func(config *certainly.CertainlyCFG) { config.RateLimit.Slip = test.slip })
+		for i, want := range test.actions {
+			addr := &net.UDPAddr{IP: net.ParseIP(fmt.Sprintf("192.0.2.%d", i+1)), Port: 53}
+			// The sources in the same /24 share the bucket, and the question name is compared case insensitively
+			name := "www.coogle.com."
+			if i%2 == 1 {
+				name = "WWW.Coogle.com."
+			}
+			if got := l.Check(addr, name, dns.TypeA); got != want {
+				t.Errorf("slip %d, query %d: %d, want %d", test.slip, i, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckSeparateBuckets(t *testing.T) {
+	l := newTestLimiter(func(config *certainly.CertainlyCFG) {
+		config.RateLimit.Exempt = certainly.Cidrslice{"198.51.100.0/24"}
+	})
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	if l.Check(addr, "www.coogle.com.", dns.TypeA) != Allow || l.Check(addr, "www.coogle.com.", dns.TypeA) != Drop {
+		t.Fatal("the repeated question wasn't dropped")
+	}
+	tests := []struct {
+		ip    string
+		qname string
+		qtype uint16
+	}{
+		{"192.0.2.1", "www.coogle.com.", dns.TypeAAAA},
+		{"192.0.2.1", "mail.coogle.com.", dns.TypeA},
+		{"192.0.3.1", "www.coogle.com.", dns.TypeA},
+		{"198.51.100.1", "www.coogle.com.", dns.TypeA},
+		{"198.51.100.1", "www.coogle.com.", dns.TypeA},
+	}
+	for _, test := range tests {
+		addr := &net.UDPAddr{IP: net.ParseIP(test.ip), Port: 53}
+		if got := l.Check(addr, test.qname, test.qtype); got != Allow {
+			t.Errorf("%s %s %s: %d, want Allow", test.ip, test.qname, dns.TypeToString[test.qtype], got)
+		}
+	}
+}
+
+func TestCheckAllPerSecond(t *testing.T) {
+	l := newTestLimiter(func(config *certainly.CertainlyCFG) {
+		config.RateLimit.ResponsesPerSecond = 0
+		config.RateLimit.AllPerSecond = 2
+	})
+	addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}
+	for i, want := range []Action{Allow, Allow, Drop} {
+		if got := l.Check(addr, fmt.Sprintf("name%d.coogle.com.", i), dns.TypeA); got != want {
+			t.Errorf("query %d: %d, want %d", i, got, want)
+		}
+	}
+	// The whole prefix is summarized under a single entry
+	if sup := l.suppressed["2001:db8::/56||"]; sup == nil || sup.dropped != 1 {
+		t.Errorf("suppressed %v, want a single entry for the prefix", l.suppressed)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	l := newTestLimiter(func(config *certainly.CertainlyCFG) { config.RateLimit.Slip = 1 })
+	for i := 0; i < maxEntries; i++ {
+		key := fmt.Sprintf("key%d", i)
+		l.buckets[key] = &bucket{last: time.Now()}
+		l.suppressed[key] = &suppression{prefix: key}
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	// A new source can't get a bucket, and is dropped instead of slipped as there's no bucket to count the slips in
+	for i := 0; i < 3; i++ {
+		if got := l.Check(addr, "www.coogle.com.", dns.TypeA); got != Drop {
+			t.Errorf("query %d: %d, want Drop", i, got)
+		}
+	}
+	if len(l.buckets) != maxEntries {
+		t.Errorf("%d buckets, want %d", len(l.buckets), maxEntries)
+	}
+	if sup := l.suppressed[""]; sup == nil || sup.prefix != "other sources" || sup.dropped != 3 {
+		t.Errorf("overflow entry %v, want 3 dropped queries from other sources", sup)
+	}
+	if stats := l.Stats(); stats.Dropped != 3 || stats.Allowed != 0 || stats.Tracked != maxEntries {
+		t.Errorf("stats %+v", stats)
+	}
+}
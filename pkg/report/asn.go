@@ -0,0 +1,84 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ASNDB maps the addresses to autonomous systems using a local database file
+type ASNDB struct {
+	ranges []asnRange
+}
+
+type asnRange struct {
+	start, end netip.Addr
+	asn        int
+	name       string
+}
+
+// LoadASNDB reads an IP to ASN database in the tab separated format of iptoasn.com, with the columns range start,
+// range end, AS number, country code and AS description. Both ip2asn-v4.tsv and ip2asn-combined.tsv work.
+func LoadASNDB(filename string) (*ASNDB, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db := &ASNDB{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected tab separated range start, range end and AS number", filename, line)
+		}
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		asn, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid AS number %q", filename, line, fields[2])
+		}
+		// AS 0 marks the address space that isn't routed
+		if asn == 0 {
+			continue
+		}
+		r := asnRange{start: start.Unmap(), end: end.Unmap(), asn: asn}
+		if len(fields) > 4 {
+			r.name = fields[4]
+		}
+		db.ranges = append(db.ranges, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+// Lookup returns the AS number and name of the address, or false if the address isn't in the database
+func (db *ASNDB) Lookup(addr netip.Addr) (int, string, bool) {
+	addr = addr.Unmap()
+	// The last range starting at or before the address
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 || db.ranges[i].end.Less(addr) {
+		return 0, "", false
+	}
+	return db.ranges[i].asn, db.ranges[i].name, true
+}
@@ -0,0 +1,77 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{"markdown", "csv", "json"}
+
+// Write writes the report in the given format
+func Write(w io.Writer, r Report, format string) error {
+	switch format {
+	case "markdown":
+		return writeMarkdown(w, r)
+	case "csv":
+		return writeCSV(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return fmt.Errorf("unknown format %s, valid formats are: %s", format, strings.Join(Formats, ", "))
+}
+
+func writeMarkdown(w io.Writer, r Report) error {
+	fmt.Fprintf(w, "# Certainly report\n\n")
+	fmt.Fprintf(w, "| | |\n|---|---|\n")
+	fmt.Fprintf(w, "| First event | %s |\n", formatTime(r.First))
+	fmt.Fprintf(w, "| Last event | %s |\n", formatTime(r.Last))
+	fmt.Fprintf(w, "| Events | %d |\n", r.Events)
+	fmt.Fprintf(w, "| Unique source IPs | %d |\n", r.UniqueIPs)
+	for _, t := range r.Tables() {
+		if len(t.Rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s\n\n| %s | events | unique IPs |\n|---|---:|---:|\n", t.Name, t.Key)
+		for _, row := range t.Rows {
+			fmt.Fprintf(w, "| %s | %d | %d |\n", markdownEscape(row.Key), row.Events, row.IPs)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeCSV writes all the tables in a single CSV with the key column name of the table in the first column. The
+// totals are on the "total" row, keyed by the time interval of the events.
+func writeCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"table", "key", "events", "ips"}) //nolint:all
+	interval := formatTime(r.First) + "/" + formatTime(r.Last)
+	cw.Write([]string{"total", interval, strconv.Itoa(r.Events), strconv.Itoa(r.UniqueIPs)}) //nolint:all
+	for _, t := range r.Tables() {
+		for _, row := range t.Rows {
+			cw.Write([]string{t.Key, row.Key, strconv.Itoa(row.Events), strconv.Itoa(row.IPs)}) //nolint:all
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// markdownEscape keeps the requested paths from breaking the table
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ", "\r", " ").Replace(s)
+}
@@ -0,0 +1,229 @@
+package report
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/happycakefriends/certainly/pkg/bitflip"
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// Count is a row of a report table
+type Count struct {
+	Key    string `json:"key"`
+	Events int    `json:"events"`
+	// IPs is the number of unique source addresses
+	IPs int `json:"ips"`
+}
+
+// Report holds the statistics of a set of events
+type Report struct {
+	// First and Last are the times of the first and the last event
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+	Events    int       `json:"events"`
+	UniqueIPs int       `json:"uniqueIPs"`
+	// Domains are counted by the captured apex domain, the events without a flip are left out
+	Domains    []Count `json:"domains"`
+	Protocols  []Count `json:"protocols"`
+	Days       []Count `json:"days"`
+	Categories []Count `json:"categories"`
+	// Bits counts the bitflip events by the index of the flipped bit, and LabelBits by the label and the bit
+	Bits      []Count `json:"bits"`
+	LabelBits []Count `json:"labelBits"`
+	// ASNs is only filled in if an ASN database was given
+	ASNs  []Count `json:"asns"`
+	Paths []Count `json:"paths"`
+	SNIs  []Count `json:"snis"`
+}
+
+// Table is a named table of the report
+type Table struct {
+	Name string
+	// Key is the heading of the key column
+	Key  string
+	Rows []Count
+}
+
+// Tables returns the tables of the report in the order they are printed
+func (r *Report) Tables() []Table {
+	return []Table{
+		{"Domains", "domain", r.Domains},
+		{"Protocols", "protocol", r.Protocols},
+		{"Days", "day", r.Days},
+		{"Flip categories", "category", r.Categories},
+		{"Flipped bits", "bit", r.Bits},
+		{"Flipped bits by label", "label/bit", r.LabelBits},
+		{"Autonomous systems", "asn", r.ASNs},
+		{"HTTP paths", "path", r.Paths},
+		{"TLS server names", "sni", r.SNIs},
+	}
+}
+
+// Builder collects the statistics from the events
+type Builder struct {
+	// asn is the optional database for counting the autonomous systems of the source addresses
+	asn *ASNDB
+	// top limits the number of rows in the domain, ASN, path and SNI tables, 0 for no limit
+	top int
+
+	report     Report
+	ips        map[string]struct{}
+	domains    *counter
+	protocols  *counter
+	days       *counter
+	categories *counter
+	bits       *counter
+	labelBits  *counter
+	asns       *counter
+	paths      *counter
+	snis       *counter
+}
+
+// NewBuilder returns an empty report builder
+func NewBuilder(asn *ASNDB, top int) *Builder {
+	return &Builder{
+		asn:        asn,
+		top:        top,
+		ips:        make(map[string]struct{}),
+		domains:    newCounter(),
+		protocols:  newCounter(),
+		days:       newCounter(),
+		categories: newCounter(),
+		bits:       newCounter(),
+		labelBits:  newCounter(),
+		asns:       newCounter(),
+		paths:      newCounter(),
+		snis:       newCounter(),
+	}
+}
+
+// Add counts a single event
+func (b *Builder) Add(event certainly.Event) {
+	if b.report.First.IsZero() || event.Time.Before(b.report.First) {
+		b.report.First = event.Time
+	}
+	if event.Time.After(b.report.Last) {
+		b.report.Last = event.Time
+	}
+	b.report.Events++
+	ip := sourceIP(event.RemoteAddr)
+	b.ips[ip] = struct{}{}
+	b.protocols.add(event.Protocol, ip)
+	b.days.add(event.Time.UTC().Format("2006-01-02"), ip)
+	if f := event.Flip; f != nil {
+		b.domains.add(f.Domain, ip)
+		if f.Category != "" {
+			b.categories.add(f.Category, ip)
+		}
+		if f.Category == bitflip.CategoryBitflip {
+			b.bits.add(strconv.Itoa(f.Bit), ip)
+			b.labelBits.add(fmt.Sprintf("%d/%d", f.Label, f.Bit), ip)
+		}
+	}
+	if b.asn != nil {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			if asn, name, ok := b.asn.Lookup(addr); ok {
+				b.asns.add(strings.TrimSpace(fmt.Sprintf("AS%d %s", asn, name)), ip)
+			}
+		}
+	}
+	if uri := event.Data["uri"]; event.Protocol == "http" && uri != "" {
+		path, _, _ := strings.Cut(uri, "?")
+		b.paths.add(path, ip)
+	}
+	if event.TLS && event.ServerName != "" {
+		b.snis.add(strings.ToLower(strings.TrimSuffix(event.ServerName, ".")), ip)
+	}
+}
+
+// Report returns the statistics of the events added so far
+func (b *Builder) Report() Report {
+	r := b.report
+	r.UniqueIPs = len(b.ips)
+	r.Domains = b.domains.top(b.top)
+	r.Protocols = b.protocols.top(0)
+	r.Days = b.days.sorted(strings.Compare)
+	r.Categories = b.categories.top(0)
+	r.Bits = b.bits.sorted(compareNumbers)
+	r.LabelBits = b.labelBits.sorted(compareNumbers)
+	r.ASNs = b.asns.top(b.top)
+	r.Paths = b.paths.top(b.top)
+	r.SNIs = b.snis.top(b.top)
+	return r
+}
+
+// sourceIP returns the address without the port
+func sourceIP(remoteAddr string) string {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().Unmap().String()
+	}
+	return remoteAddr
+}
+
+// counter counts the events and the unique source addresses by key
+type counter struct {
+	events map[string]int
+	ips    map[string]map[string]struct{}
+}
+
+func newCounter() *counter {
+	return &counter{events: make(map[string]int), ips: make(map[string]map[string]struct{})}
+}
+
+func (c *counter) add(key, ip string) {
+	c.events[key]++
+	if c.ips[key] == nil {
+		c.ips[key] = make(map[string]struct{})
+	}
+	c.ips[key][ip] = struct{}{}
+}
+
+func (c *counter) rows() []Count {
+	rows := []Count{}
+	for key, events := range c.events {
+		rows = append(rows, Count{Key: key, Events: events, IPs: len(c.ips[key])})
+	}
+	return rows
+}
+
+// top returns the rows with the most events first, limited to n rows if n is positive
+func (c *counter) top(n int) []Count {
+	rows := c.rows()
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Events != rows[j].Events {
+			return rows[i].Events > rows[j].Events
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	if n > 0 && len(rows) > n {
+		rows = rows[:n]
+	}
+	return rows
+}
+
+// sorted returns the rows in the order of the keys
+func (c *counter) sorted(compare func(a, b string) int) []Count {
+	rows := c.rows()
+	sort.Slice(rows, func(i, j int) bool {
+		return compare(rows[i].Key, rows[j].Key) < 0
+	})
+	return rows
+}
+
+// compareNumbers compares keys of slash separated numbers, such as the label/bit keys, numerically
+func compareNumbers(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Negative answers without a SOA record in the authority section are cached for this long
+	defaultNegativeTTL = 60
+	maxTTL             = 86400
+	maxCacheEntries    = 10000
+)
+
+type cacheKey struct {
+	name  string
+	qtype uint16
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// cache stores both the positive and negative answers for their TTL
+type cache struct {
+	mutex   sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[cacheKey]cacheEntry)}
+}
+
+// get returns a copy of the cached answer with the TTLs decremented by the time spent in cache
+func (c *cache) get(name string, qtype uint16) (*dns.Msg, bool) {
+	c.mutex.Lock()
+	entry, ok := c.entries[cacheKey{name, qtype}]
+	c.mutex.Unlock()
+	now := time.Now()
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	elapsed := uint32(now.Sub(entry.stored).Seconds())
+	msg := entry.msg.Copy()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	return msg, true
+}
+
+func (c *cache) set(name string, qtype uint16, msg *dns.Msg) {
+	ttl, ok := cacheTTL(msg)
+	if !ok || ttl == 0 {
+		return
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= maxCacheEntries {
+		c.pruneLocked(now)
+	}
+	if len(c.entries) >= maxCacheEntries {
+		return
+	}
+	c.entries[cacheKey{name, qtype}] = cacheEntry{msg: msg.Copy(), stored: now, expires: now.Add(time.Duration(ttl) * time.Second)}
+}
+
+func (c *cache) pruneLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheTTL returns the time in seconds the answer can be cached for. Positive answers are cached for the
+// lowest TTL of the answer section, negative answers according to the SOA record as defined in RFC 2308
+func cacheTTL(msg *dns.Msg) (uint32, bool) {
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return 0, false
+	}
+	if msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0 {
+		ttl := uint32(maxTTL)
+		for _, rr := range msg.Answer {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		return ttl, true
+	}
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Minttl
+			if soa.Hdr.Ttl < ttl {
+				ttl = soa.Hdr.Ttl
+			}
+			return ttl, true
+		}
+	}
+	return defaultNegativeTTL, true
+}
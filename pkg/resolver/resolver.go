@@ -0,0 +1,186 @@
+package resolver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver sends recursive DNS questions to a list of upstream servers, trying the next upstream on failure.
+//
+// Upstreams are defined as "host:port" or "udp://host:port" for plain DNS with TCP fallback for truncated
+// answers, "tcp://host:port" for DNS over TCP, "tls://host:port" for DNS over TLS and
+// "https://host/dns-query" for DNS over HTTPS.
+type Resolver struct {
+	Upstreams  []string
+	Timeout    time.Duration
+	Retries    int
+	cache      *cache
+	httpClient *http.Client
+}
+
+// New creates a new Resolver, answers are cached for their TTL if cache is set
+func New(upstreams []string, timeout time.Duration, retries int, cache bool) *Resolver {
+	r := &Resolver{
+		Upstreams:  upstreams,
+		Timeout:    timeout,
+		Retries:    retries,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+	if cache {
+		r.cache = newCache()
+	}
+	return r
+}
+
+// Query sends a recursive question of type qtype for name to the upstreams
+func (r *Resolver) Query(name string, qtype uint16) (*dns.Msg, error) {
+	name = dns.Fqdn(strings.ToLower(name))
+	if r.cache != nil {
+		if msg, ok := r.cache.get(name, qtype); ok {
+			return msg, nil
+		}
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+	in, err := r.Exchange(msg)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.set(name, qtype, in)
+	}
+	return in, nil
+}
+
+// Exchange sends the message to the upstreams, bypassing the cache. Upstreams returning SERVFAIL are skipped
+// if there's another upstream left to try.
+func (r *Resolver) Exchange(msg *dns.Msg) (*dns.Msg, error) {
+	if len(r.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream resolvers configured")
+	}
+	var lastResp *dns.Msg
+	var lastErr error
+	for attempt := 0; attempt <= r.Retries; attempt++ {
+		for _, upstream := range r.Upstreams {
+			in, err := r.exchange(msg, upstream)
+			if err != nil {
+				lastErr = fmt.Errorf("upstream %s: %s", upstream, err)
+				continue
+			}
+			if in.Rcode == dns.RcodeServerFailure {
+				lastResp = in
+				continue
+			}
+			return in, nil
+		}
+	}
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+func (r *Resolver) exchange(msg *dns.Msg, upstream string) (*dns.Msg, error) {
+	scheme, addr := splitUpstream(upstream)
+	switch scheme {
+	case "https":
+		return r.exchangeHTTPS(msg, upstream)
+	case "tls":
+		client := &dns.Client{Net: "tcp-tls", Timeout: r.Timeout, TLSConfig: &tls.Config{ServerName: hostname(addr), MinVersion: tls.VersionTLS12}}
+		in, _, err := client.Exchange(msg, withPort(addr, "853"))
+		return in, err
+	case "tcp":
+		client := &dns.Client{Net: "tcp", Timeout: r.Timeout}
+		in, _, err := client.Exchange(msg, withPort(addr, "53"))
+		return in, err
+	case "udp":
+		client := &dns.Client{Net: "udp", Timeout: r.Timeout}
+		in, _, err := client.Exchange(msg, withPort(addr, "53"))
+		if err == nil && in.Truncated {
+			client.Net = "tcp"
+			in, _, err = client.Exchange(msg, withPort(addr, "53"))
+		}
+		return in, err
+	}
+	return nil, fmt.Errorf("unsupported upstream protocol %s", scheme)
+}
+
+// exchangeHTTPS sends the question using DNS over HTTPS as defined in RFC 8484
+func (r *Resolver) exchangeHTTPS(msg *dns.Msg, url string) (*dns.Msg, error) {
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	in.Id = msg.Id
+	return in, nil
+}
+
+// ValidUpstream checks that the upstream definition is in one of the supported formats
+func ValidUpstream(upstream string) error {
+	scheme, addr := splitUpstream(upstream)
+	switch scheme {
+	case "udp", "tcp", "tls", "https":
+	default:
+		return fmt.Errorf("unsupported upstream protocol %s in %s", scheme, upstream)
+	}
+	if addr == "" {
+		return fmt.Errorf("missing address in upstream %s", upstream)
+	}
+	return nil
+}
+
+func splitUpstream(upstream string) (string, string) {
+	parts := strings.SplitN(upstream, "://", 2)
+	if len(parts) == 1 {
+		return "udp", parts[0]
+	}
+	return strings.ToLower(parts[0]), parts[1]
+}
+
+// withPort adds the default port to the address if it doesn't have one
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+func hostname(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
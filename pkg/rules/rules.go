@@ -0,0 +1,79 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Rule is a single regex matching rule
+type Rule struct {
+	// Name identifies the rule in the logs and the event store, defaults to the pattern
+	Name    string
+	Pattern string
+	// Rules with a higher priority are evaluated first, rules with the same priority in the order they were given
+	Priority int
+	// Value is the result of the rule, for example the template file of an injection rule
+	Value string
+	re    *regexp.Regexp
+}
+
+// Set is an ordered set of compiled rules. It's safe for concurrent use, as it's never modified after Compile.
+type Set struct {
+	rules []*Rule
+}
+
+// Compile compiles the patterns of the rules and orders them for evaluation. An error is returned for the first
+// pattern that doesn't compile.
+func Compile(rules []Rule) (*Set, error) {
+	s := &Set{rules: make([]*Rule, 0, len(rules))}
+	for _, r := range rules {
+		r := r
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.re = re
+		if r.Name == "" {
+			r.Name = r.Pattern
+		}
+		s.rules = append(s.rules, &r)
+	}
+	sort.SliceStable(s.rules, func(i, j int) bool {
+		return s.rules[i].Priority > s.rules[j].Priority
+	})
+	return s, nil
+}
+
+// FromPatterns returns a rule for every non-empty pattern, named after its position in the list
+func FromPatterns(name string, patterns []string) []Rule {
+	rules := []Rule{}
+	for i, p := range patterns {
+		if p == "" {
+			continue
+		}
+		rules = append(rules, Rule{Name: fmt.Sprintf("%s[%d]", name, i), Pattern: p})
+	}
+	return rules
+}
+
+// Match returns the first rule matching the data, or nil if none of them match
+func (s *Set) Match(data string) *Rule {
+	if s == nil {
+		return nil
+	}
+	for _, r := range s.rules {
+		if r.re.MatchString(data) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Rules returns the rules in evaluation order
+func (s *Set) Rules() []*Rule {
+	if s == nil {
+		return nil
+	}
+	return s.rules
+}
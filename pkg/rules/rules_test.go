@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchOrder(t *testing.T) {
+	set, err := Compile([]Rule{
+		{Name: "any-login", Pattern: `/login`, Value: "generic.html"},
+		{Name: "api", Pattern: `^/api/`, Value: "api.html"},
+		{Name: "login-php", Pattern: `/login\.php`, Priority: 10, Value: "php.html"},
+		{Name: "second-login", Pattern: `/login`, Value: "never.html"},
+		{Name: "admin", Pattern: `^/admin`, Priority: 5, Value: "admin.html"},
+		{Pattern: `\.env$`, Priority: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data string
+		rule string
+	}{
+		// The higher priority wins over the order
+		{"/login.php", "login-php"},
+		{"/admin/login", "admin"},
+		// Rules with the same priority are evaluated in the order they were given
+		{"/user/login", "any-login"},
+		{"/api/login", "any-login"},
+		{"/api/users", "api"},
+		// The name defaults to the pattern
+		{"/.env", `\.env$`},
+		{"/index.html", ""},
+	}
+	for _, test := range tests {
+		name := ""
+		if r := set.Match(test.data); r != nil {
+			name = r.Name
+		}
+		if name != test.rule {
+			t.Errorf("Match(%s) = %q, want %q", test.data, name, test.rule)
+		}
+	}
+
+	order := []string{}
+	for _, r := range set.Rules() {
+		order = append(order, r.Name)
+	}
+	want := `login-php,admin,\.env$,any-login,api,second-login`
+	if strings.Join(order, ",") != want {
+		t.Errorf("Rules() = %s, want %s", strings.Join(order, ","), want)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile([]Rule{{Name: "ok", Pattern: "a"}, {Name: "broken", Pattern: "a("}})
+	if err == nil || !strings.HasPrefix(err.Error(), "rule broken:") {
+		t.Errorf("Compile error = %v, want an error for the rule broken", err)
+	}
+}
+
+func TestFromPatterns(t *testing.T) {
+	set, err := Compile(FromPatterns("notification.dns_filters", []string{"^_dmarc", "", "scanner"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, r := range set.Rules() {
+		names = append(names, r.Name)
+	}
+	// The empty pattern is skipped, but keeps its position in the names
+	if got := strings.Join(names, ","); got != "notification.dns_filters[0],notification.dns_filters[2]" {
+		t.Errorf("rule names %s", got)
+	}
+	if r := set.Match("scanner.example.com"); r == nil || r.Name != "notification.dns_filters[2]" {
+		t.Errorf("Match(scanner.example.com) = %v", r)
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var set *Set
+	if set.Match("anything") != nil || set.Rules() != nil {
+		t.Error("a nil set matched")
+	}
+}
@@ -2,12 +2,19 @@ package smtpd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
 	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
+	"github.com/happycakefriends/certainly/pkg/lifecycle"
 	"github.com/happycakefriends/certainly/pkg/notification"
 	"github.com/mhale/smtpd"
 	"go.uber.org/zap"
@@ -18,20 +25,20 @@ type Smtpd struct {
 	TLSConfig    *tls.Config
 	Logger       *zap.SugaredLogger
 	Notification *notification.Notifications
-	errChan      chan error
+	Events       *events.Events
 }
 
-func Initialize(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, errChan chan error) *Smtpd {
-	return &Smtpd{config, tlsconfig, logger, notification, errChan}
+func Initialize(config *certainly.CertainlyCFG, tlsconfig *tls.Config, logger *zap.SugaredLogger, notification *notification.Notifications, events *events.Events) *Smtpd {
+	return &Smtpd{config, tlsconfig, logger, notification, events}
 }
 
-func (s *Smtpd) Start() {
-	// SMTP
-	go s.ListenAndServe(25, false)
-	// Submission
-	go s.ListenAndServe(587, false)
-	// SMTPS
-	go s.ListenAndServe(465, true)
+// Listeners returns the SMTP, submission and SMTPS listeners
+func (s *Smtpd) Listeners() []certainly.Listener {
+	return []certainly.Listener{
+		&smtpListener{s: s, name: "smtp", port: 25},
+		&smtpListener{s: s, name: "submission", port: 587},
+		&smtpListener{s: s, name: "smtps", port: 465, smtps: true},
+	}
 }
 
 func (s *Smtpd) mailHandler(origin net.Addr, from string, to []string, data []byte) error {
@@ -43,12 +50,21 @@ func (s *Smtpd) mailHandler(origin net.Addr, from string, to []string, data []by
 		return err
 	}
 	subject := msg.Header.Get("Subject")
+	event := certainly.NewEvent("smtp", origin.String())
+	event.ServerName = recipientDomain(to)
+	event.Data["type"] = "mail"
+	event.Data["from"] = from
+	event.Data["to"] = strings.Join(to, ",")
+	event.Data["subject"] = subject
+	event.Data["data"] = string(data)
+	s.Events.Record(event)
 	s.Logger.Infow("Received mail",
 		"remoteAddr", origin.String(),
 		"from", from,
 		"to", to[0],
 		"subject", subject,
-		"data", string(data))
+		"data", string(data),
+		"session", event.SessionID)
 	return nil
 }
 
@@ -59,33 +75,91 @@ func (s *Smtpd) rcptHandler(remoteAddr net.Addr, from string, to string) bool {
 
 func (s *Smtpd) authHandler(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
 	// Oh, absolutely, this is a valid user. Let me log the information for you
+	event := certainly.NewEvent("smtp", remoteAddr.String())
+	event.Data["type"] = "auth"
+	event.Data["mechanism"] = mechanism
+	event.Data["username"] = string(username)
+	event.Data["password"] = string(password)
+	event.Data["shared"] = string(shared)
+	s.Events.Record(event)
 	s.Notification.Notify("smtp", fmt.Sprintf(`
 SMTP credentials from: %s
+Session: %s
 Auth Mechanism: %s
 Username: %s
 Password: %s
 Shared secret (if any): %s`,
-		remoteAddr.String(), mechanism,
+		remoteAddr.String(), event.SessionID, mechanism,
 		string(username), string(password), string(shared)))
 	s.Logger.Infow("Received smtp auth credentials",
 		"remoteAddr", remoteAddr.String(),
 		"mechanism", mechanism,
 		"username", string(username),
 		"password", string(password),
-		"shared", string(shared))
+		"shared", string(shared),
+		"session", event.SessionID)
 	return true, nil
 }
 
-func (s *Smtpd) ListenAndServe(port int, smtps bool) {
+// recipientDomain returns the domain part of the first recipient address
+func recipientDomain(to []string) string {
+	if len(to) == 0 {
+		return ""
+	}
+	parts := strings.Split(to[0], "@")
+	return strings.Trim(parts[len(parts)-1], "<> ")
+}
+
+// smtpListener is a single SMTP port. The SMTP library doesn't wait for the open sessions on shutdown,
+// so the connections are tracked here.
+type smtpListener struct {
+	s     *Smtpd
+	name  string
+	port  int
+	smtps bool
+	srv   *smtpd.Server
+	ln    *lifecycle.TrackedListener
+}
+
+func (l *smtpListener) addr() string {
+	return net.JoinHostPort(l.s.Config.General.IP, strconv.Itoa(l.port))
+}
+
+func (l *smtpListener) Name() string {
+	return l.name + " " + l.addr()
+}
+
+func (l *smtpListener) Listen() error {
+	hostname, _ := os.Hostname()
 	srv := &smtpd.Server{
 		AuthMechs:   map[string]bool{"PLAIN": true, "LOGIN": true},
-		Addr:        fmt.Sprintf("%s:%d", s.Config.General.IP, port),
-		Handler:     s.mailHandler,
-		HandlerRcpt: s.rcptHandler,
+		Addr:        l.addr(),
+		Handler:     l.s.mailHandler,
+		HandlerRcpt: l.s.rcptHandler,
 		Appname:     "HCF Certainly SMTPD v0.1",
-		AuthHandler: s.authHandler,
-		TLSListener: smtps,
-		Hostname:    "",
+		AuthHandler: l.s.authHandler,
+		TLSListener: l.smtps,
+		Hostname:    hostname,
+		Timeout:     5 * time.Minute,
 	}
-	s.errChan <- srv.ListenAndServe()
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	l.srv, l.ln = srv, lifecycle.NewTrackedListener(ln)
+	return nil
+}
+
+func (l *smtpListener) Serve() error {
+	var ln net.Listener = l.ln
+	// If TLSListener is enabled, listen for TLS connections only
+	if l.srv.TLSConfig != nil && l.srv.TLSListener {
+		ln = tls.NewListener(ln, l.srv.TLSConfig)
+	}
+	return l.srv.Serve(ln)
+}
+
+func (l *smtpListener) Shutdown(ctx context.Context) error {
+	l.srv.Close()
+	return l.ln.Drain(ctx)
 }
@@ -0,0 +1,18 @@
+package util
+
+import "net"
+
+// AddrIP returns the IP address of a network address, or nil if it doesn't have one
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
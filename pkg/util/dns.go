@@ -2,65 +2,73 @@ package util
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/resolver"
 	"github.com/miekg/dns"
 )
 
-// GetA fetches the A records for a domain
+var (
+	upstreamMutex sync.RWMutex
+	upstream      = resolver.New([]string{"8.8.8.8:53"}, 5*time.Second, 1, true)
+)
+
+// SetResolver sets the resolver used for the upstream lookups
+func SetResolver(r *resolver.Resolver) {
+	upstreamMutex.Lock()
+	defer upstreamMutex.Unlock()
+	upstream = r
+}
+
+// Query sends a recursive question of type qtype for domain to the configured upstream resolver
+func Query(domain string, qtype uint16) (*dns.Msg, error) {
+	upstreamMutex.RLock()
+	r := upstream
+	upstreamMutex.RUnlock()
+	return r.Query(domain, qtype)
+}
+
+// maxCNAMEChain is the maximum number of CNAME records followed before giving up
+const maxCNAMEChain = 10
+
+// Resolution is the result of following an upstream name through its CNAME chain
+type Resolution struct {
+	// Path lists the names followed, starting from the queried name
+	Path  []string
+	Addrs []string
+	// Exists is set if the name has addresses or a CNAME record, even if the CNAME target doesn't resolve
+	Exists bool
+}
+
+// GetA fetches the A record for a domain, following CNAME chains
 func GetA(domain string) (certainly.UpstreamNSRecord, error) {
 	record := certainly.UpstreamNSRecord{IPv6: false}
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-	msg.RecursionDesired = true
-
-	in, err := dns.Exchange(msg, "8.8.8.8:53")
+	_, addrs, err := followChain(domain, dns.TypeA)
 	if err != nil {
 		return record, err
 	}
-
-	for _, ans := range in.Answer {
-		if a, ok := ans.(*dns.A); ok {
-			record.Addr = a.A.String()
-			return record, nil
-		} else {
-			return record, fmt.Errorf("unexpected record returned with A query to domain %s", domain)
-		}
-	}
-	return record, fmt.Errorf("no A record found")
+	record.Addr = addrs[0]
+	return record, nil
 }
 
-// GetA fetches the AAAA record for a domain
+// GetAAAA fetches the AAAA record for a domain, following CNAME chains
 func GetAAAA(domain string) (certainly.UpstreamNSRecord, error) {
 	record := certainly.UpstreamNSRecord{IPv6: true}
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeAAAA)
-	msg.RecursionDesired = true
-
-	in, err := dns.Exchange(msg, "8.8.8.8:53")
+	_, addrs, err := followChain(domain, dns.TypeAAAA)
 	if err != nil {
 		return record, err
 	}
-
-	for _, ans := range in.Answer {
-		if a, ok := ans.(*dns.AAAA); ok {
-			record.Addr = a.AAAA.String()
-			return record, nil
-		} else {
-			return record, fmt.Errorf("unexpected record returned with AAAA query to domain %s", domain)
-		}
-	}
-	return record, fmt.Errorf("no AAAA record found")
+	record.Addr = addrs[0]
+	return record, nil
 }
 
 // GetCNAME fetches the CNAME record for a domain
 func GetCNAME(domain string) (certainly.UpstreamNSRecord, error) {
 	record := certainly.UpstreamNSRecord{}
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeCNAME)
-	msg.RecursionDesired = true
-
-	in, err := dns.Exchange(msg, "8.8.8.8:53")
+	in, err := Query(domain, dns.TypeCNAME)
 	if err != nil {
 		return record, err
 	}
@@ -69,23 +77,92 @@ func GetCNAME(domain string) (certainly.UpstreamNSRecord, error) {
 		if a, ok := ans.(*dns.CNAME); ok {
 			record.Addr = a.Target
 			return record, nil
-		} else {
-			return record, fmt.Errorf("unexpected record returned with CNAME query to domain %s", domain)
 		}
 	}
 	return record, fmt.Errorf("no CNAME record found")
 }
 
+// Resolve looks up the A, or if those don't exist, the AAAA records for the domain following the CNAME chain
+func Resolve(domain string) (Resolution, error) {
+	path, addrs, err := followChain(domain, dns.TypeA)
+	if err == nil {
+		return Resolution{Path: path, Addrs: addrs, Exists: true}, nil
+	}
+	path6, addrs6, err6 := followChain(domain, dns.TypeAAAA)
+	if err6 == nil {
+		return Resolution{Path: path6, Addrs: addrs6, Exists: true}, nil
+	}
+	return Resolution{Path: path, Exists: len(path) > 1}, err
+}
+
 // ExistsUpstream checks if there is a valid A, AAAA or CNAME record in the upstream DNS zone
 func ExistsUpstream(domain string) bool {
-	_, err := GetA(domain)
-	if err == nil {
-		return true
+	res, _ := Resolve(domain)
+	return res.Exists
+}
+
+// followChain queries the domain for records of type qtype, following the CNAME records until the addresses are found.
+// Resolvers usually return the whole chain in a single answer, but the remaining part of the chain is queried
+// separately if they don't.
+func followChain(domain string, qtype uint16) ([]string, []string, error) {
+	current := dns.Fqdn(strings.ToLower(domain))
+	path := []string{current}
+	seen := map[string]bool{current: true}
+	for queries := 0; queries < maxCNAMEChain; queries++ {
+		in, err := Query(current, qtype)
+		if err != nil {
+			return path, nil, err
+		}
+		queried := current
+		for {
+			addrs, target := answerFor(in.Answer, current, qtype)
+			if len(addrs) > 0 {
+				return path, addrs, nil
+			}
+			if target == "" {
+				break
+			}
+			if seen[target] {
+				return path, nil, fmt.Errorf("CNAME loop detected at %s", target)
+			}
+			if len(path) > maxCNAMEChain {
+				return path, nil, fmt.Errorf("CNAME chain for %s is too long", domain)
+			}
+			seen[target] = true
+			path = append(path, target)
+			current = target
+		}
+		// The chain is collected before looking at the response code, so that a dangling CNAME still shows in the path
+		if in.Rcode != dns.RcodeSuccess {
+			return path, nil, fmt.Errorf("%s query for %s returned %s", dns.TypeToString[qtype], current, dns.RcodeToString[in.Rcode])
+		}
+		if current == queried {
+			return path, nil, fmt.Errorf("no %s record found for %s", dns.TypeToString[qtype], current)
+		}
 	}
-	_, err = GetAAAA(domain)
-	if err == nil {
-		return true
+	return path, nil, fmt.Errorf("CNAME chain for %s is too long", domain)
+}
+
+// answerFor returns the addresses or the CNAME target for name from the answer section
+func answerFor(answer []dns.RR, name string, qtype uint16) ([]string, string) {
+	addrs := []string{}
+	target := ""
+	for _, rr := range answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch v := rr.(type) {
+		case *dns.A:
+			if qtype == dns.TypeA {
+				addrs = append(addrs, v.A.String())
+			}
+		case *dns.AAAA:
+			if qtype == dns.TypeAAAA {
+				addrs = append(addrs, v.AAAA.String())
+			}
+		case *dns.CNAME:
+			target = strings.ToLower(v.Target)
+		}
 	}
-	_, err = GetCNAME(domain)
-	return err == nil
+	return addrs, target
 }
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/happycakefriends/certainly/pkg/resolver"
+)
+
+// stubZone answers the questions from a fixed set of records. The answer records are looked up by the question name,
+// and the names missing from names get NXDOMAIN.
+type stubZone struct {
+	answers map[string][]string
+	names   map[string]bool
+}
+
+func (z stubZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	for _, s := range z.answers[q.Name] {
+		rr, _ := dns.NewRR(s)
+		if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	if !z.names[q.Name] {
+		m.Rcode = dns.RcodeNameError
+	}
+	_ = w.WriteMsg(m)
+}
+
+// useStub starts a DNS server for the zone on the loopback address and sets it as the upstream resolver
+func useStub(t *testing.T, zone stubZone) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: zone, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe() //nolint:all
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+		SetResolver(resolver.New([]string{"8.8.8.8:53"}, 5*time.Second, 1, true))
+	})
+	SetResolver(resolver.New([]string{pc.LocalAddr().String()}, time.Second, 0, false))
+}
+
+func TestResolve(t *testing.T) {
+	useStub(t, stubZone{
+		answers: map[string][]string{
+			"whole.example.": {
+				"whole.example. 60 IN CNAME edge.example.",
+				"edge.example. 60 IN A 192.0.2.1",
+			},
+			"split.example.":    {"split.example. 60 IN CNAME edge.example."},
+			"edge.example.":     {"edge.example. 60 IN A 192.0.2.1"},
+			"v6.example.":       {"v6.example. 60 IN AAAA 2001:db8::1"},
+			"dangling.example.": {"dangling.example. 60 IN CNAME gone.example."},
+			"loop1.example.":    {"loop1.example. 60 IN CNAME loop2.example."},
+			"loop2.example.":    {"loop2.example. 60 IN CNAME loop1.example."},
+		},
+		names: map[string]bool{
+			"whole.example.": true,
+			"split.example.": true,
+			"edge.example.":  true,
+			"v6.example.":    true,
+			"loop1.example.": true,
+			"loop2.example.": true,
+		},
+	})
+	tests := []struct {
+		domain  string
+		path    []string
+		addrs   []string
+		exists  bool
+		wantErr bool
+	}{
+		{"whole.example", []string{"whole.example.", "edge.example."}, []string{"192.0.2.1"}, true, false},
+		{"Split.Example", []string{"split.example.", "edge.example."}, []string{"192.0.2.1"}, true, false},
+		{"v6.example", []string{"v6.example."}, []string{"2001:db8::1"}, true, false},
+		// The CNAME target doesn't exist, but the name itself does
+		{"dangling.example", []string{"dangling.example.", "gone.example."}, nil, true, true},
+		{"loop1.example", []string{"loop1.example.", "loop2.example."}, nil, true, true},
+		{"missing.example", []string{"missing.example."}, nil, false, true},
+	}
+	for _, test := range tests {
+		res, err := Resolve(test.domain)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Resolve(%s) error = %v, want error %t", test.domain, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(res.Path, test.path) {
+			t.Errorf("Resolve(%s) path = %v, want %v", test.domain, res.Path, test.path)
+		}
+		if !reflect.DeepEqual(res.Addrs, test.addrs) {
+			t.Errorf("Resolve(%s) addrs = %v, want %v", test.domain, res.Addrs, test.addrs)
+		}
+		if res.Exists != test.exists {
+			t.Errorf("Resolve(%s) exists = %t, want %t", test.domain, res.Exists, test.exists)
+		}
+		if ExistsUpstream(test.domain) != test.exists {
+			t.Errorf("ExistsUpstream(%s) = %t, want %t", test.domain, !test.exists, test.exists)
+		}
+	}
+}
@@ -5,41 +5,50 @@ import (
 	"strings"
 )
 
+// ReplaceApex replaces the rewrite source apex domain of target with the rewrite target. The source is matched case
+// insensitively, and the rest of the name keeps its case.
 func ReplaceApex(target string, rewrites map[string]string) string {
-	// Rewrites map is not dot terminated
-	tmptarget := target
-	if strings.HasSuffix(target, ".") {
-		tmptarget = target[:len(target)-1]
+	if from, to, ok := RewriteFor(target, rewrites); ok {
+		return replaceLast(target, from, to)
 	}
+	return target
+}
+
+// RewriteFor returns the rewrite rule source and target apex domains matching target. The names are compared case
+// insensitively, as resolvers randomize the case of the questions, and the source is returned in lowercase.
+func RewriteFor(target string, rewrites map[string]string) (string, string, bool) {
+	// Rewrites map is not dot terminated
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
 
 	for from, to := range rewrites {
-		if strings.HasSuffix(tmptarget, fmt.Sprintf(".%s", from)) || tmptarget == from {
-			return replaceLast(target, from, to)
+		from = strings.ToLower(from)
+		if strings.HasSuffix(target, fmt.Sprintf(".%s", from)) || target == from {
+			return from, to, true
 		}
 	}
-	return target
+	return "", "", false
 }
 
+// replaceLast replaces the last case insensitive match of from in data with to
 func replaceLast(data, from, to string) string {
-	i := strings.LastIndex(data, from)
-	if i == -1 {
-		return data
+	for i := len(data) - len(from); i >= 0; i-- {
+		if strings.EqualFold(data[i:i+len(from)], from) {
+			return data[:i] + to + data[i+len(from):]
+		}
 	}
-	return data[:i] + to + data[i+len(from):]
+	return data
 }
 
 func ShouldRewrite(target string, rewrites map[string]string) bool {
-	// Rewrites map is not dot terminated
-	if strings.HasSuffix(target, ".") {
-		target = target[:len(target)-1]
-	}
+	_, _, ok := RewriteFor(target, rewrites)
+	return ok
+}
 
-	for from, _ := range rewrites {
-		if strings.HasSuffix(target, fmt.Sprintf(".%s", from)) || target == from {
-			return true
-		}
-	}
-	return false
+// InDomain checks if name is the apex domain itself or one of its subdomains
+func InDomain(name, apex string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	apex = strings.ToLower(strings.TrimSuffix(apex, "."))
+	return name == apex || strings.HasSuffix(name, "."+apex)
 }
 
 func HasApexDomain(target, apex string) bool {
@@ -0,0 +1,25 @@
+package util
+
+import "testing"
+
+func TestReplaceApex(t *testing.T) {
+	rewrites := map[string]string{"coogle.com": "google.com", "Woogle.com": "google.com"}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"www.coogle.com.", "www.google.com."},
+		{"coogle.com", "google.com"},
+		// The source is matched case insensitively, the rest keeps its case
+		{"WwW.CoOgLe.CoM.", "WwW.google.com."},
+		{"API.woogle.com", "API.google.com"},
+		{"coogle.com.coogle.com", "coogle.com.google.com"},
+		{"www.example.com", "www.example.com"},
+		{"xcoogle.com", "xcoogle.com"},
+	}
+	for _, test := range tests {
+		if got := ReplaceApex(test.target, rewrites); got != test.want {
+			t.Errorf("ReplaceApex(%s) = %s, want %s", test.target, got, test.want)
+		}
+	}
+}
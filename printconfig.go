@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// printConfigCommand prints the effective configuration with the defaults, the secret files and the environment
+// variable overrides applied, and the secrets redacted
+func printConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("print-config", flag.ExitOnError)
+	configPtr := fs.String("c", "./config.cfg", "config file location")
+	fs.Parse(args) //nolint:all
+
+	config, usedConfigFile, err := certainly.ReadConfig(*configPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	fmt.Printf("# Effective configuration of %s, secrets redacted\n", usedConfigFile)
+	if err := toml.NewEncoder(os.Stdout).Encode(certainly.Redacted(config)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
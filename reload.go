@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+)
+
+// watchInterval is how often the configuration file is checked for changes
+const watchInterval = 5 * time.Second
+
+// reloader re-reads the configuration file and applies it to the running servers. The servers are reloaded in order
+// and the nameserver goes first, as it's the only one that can reject a configuration that passed the validation.
+type reloader struct {
+	file    string
+	logger  *zap.SugaredLogger
+	targets []certainly.Reloadable
+	// mu serializes the reloads and guards the config
+	mu      sync.Mutex
+	config  *certainly.CertainlyCFG
+	modTime time.Time
+}
+
+func newReloader(file string, config *certainly.CertainlyCFG, logger *zap.SugaredLogger) *reloader {
+	r := &reloader{file: file, config: config, logger: logger}
+	if info, err := os.Stat(file); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// Add adds servers to apply the reloaded configuration to
+func (r *reloader) Add(targets ...certainly.Reloadable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets = append(r.targets, targets...)
+}
+
+// Config returns the configuration currently in use
+func (r *reloader) Config() *certainly.CertainlyCFG {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// Reload reads the configuration file and applies it. If the new configuration is invalid the running one is kept,
+// and if a server rejects it, the servers that were already reloaded get the running configuration back.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, err := os.Stat(r.file); err == nil {
+		r.modTime = info.ModTime()
+	}
+	config, usedConfigFile, err := certainly.ReadConfig(r.file)
+	if err != nil {
+		return err
+	}
+	if usedConfigFile != r.file {
+		return fmt.Errorf("configuration file %s not found", r.file)
+	}
+	restart := certainly.KeepStartupSettings(r.config, &config)
+	for i, target := range r.targets {
+		if err := target.Reload(&config); err != nil {
+			r.rollback(r.targets[:i])
+			return err
+		}
+	}
+	r.config = &config
+	r.logger.Infow("Reloaded configuration",
+		"file", r.file)
+	if len(restart) > 0 {
+		r.logger.Warnw("Some of the changed settings only take effect after a restart",
+			"settings", restart)
+	}
+	return nil
+}
+
+// rollback applies the running configuration again to the servers that were reloaded before one of them failed
+func (r *reloader) rollback(applied []certainly.Reloadable) {
+	for _, target := range applied {
+		if err := target.Reload(r.config); err != nil {
+			r.logger.Errorw("Could not restore the running configuration",
+				"target", fmt.Sprintf("%T", target),
+				"error", err)
+			continue
+		}
+		r.logger.Warnw("Restored the running configuration after a failed reload",
+			"target", fmt.Sprintf("%T", target))
+	}
+}
+
+// watch reloads the configuration whenever the modification time of the file changes
+func (r *reloader) watch() {
+	for range time.Tick(watchInterval) {
+		info, err := os.Stat(r.file)
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		modified := !info.ModTime().Equal(r.modTime)
+		r.mu.Unlock()
+		if !modified {
+			continue
+		}
+		r.logger.Infow("Configuration file changed, reloading",
+			"file", r.file)
+		r.reloadAndLog()
+	}
+}
+
+func (r *reloader) reloadAndLog() {
+	if err := r.Reload(); err != nil {
+		r.logger.Errorw("Could not reload the configuration, keeping the running configuration",
+			"file", r.file,
+			"error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/events"
+	"github.com/happycakefriends/certainly/pkg/report"
+)
+
+// reportCommand prints the research statistics of the events from the event store or a JSON lines file
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configPtr := fs.String("c", "./config.cfg", "config file location")
+	dbPtr := fs.String("db", "", "event database location, overrides the path from config")
+	inputPtr := fs.String("i", "", "read the events from a JSON lines file written by the events command instead of the database. Use - for stdin")
+	fromPtr := fs.String("from", "", "only count events after this time (RFC3339 or YYYY-MM-DD)")
+	toPtr := fs.String("to", "", "only count events up to this time (RFC3339, or YYYY-MM-DD for the end of the day)")
+	protoPtr := fs.String("protocol", "", "only count events for this protocol: dns, http, smtp or imap")
+	asnPtr := fs.String("asn-db", "", "IP to ASN database in the tab separated format of iptoasn.com, for counting the autonomous systems")
+	topPtr := fs.Int("top", 20, "number of rows in the domain, ASN, path and SNI tables, 0 for all")
+	formatPtr := fs.String("format", "markdown", "output format: "+strings.Join(report.Formats, ", "))
+	fs.Parse(args) //nolint:all
+
+	if !isFormat(*formatPtr) {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %s, valid formats are: %s\n", *formatPtr, strings.Join(report.Formats, ", "))
+		return 1
+	}
+	from, err := parseTimeFlag(*fromPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -from value: %s\n", err)
+		return 1
+	}
+	to, err := parseEndTimeFlag(*toPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -to value: %s\n", err)
+		return 1
+	}
+	var asn *report.ASNDB
+	if *asnPtr != "" {
+		if asn, err = report.LoadASNDB(*asnPtr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 1
+		}
+	}
+
+	builder := report.NewBuilder(asn, *topPtr)
+	add := func(event certainly.Event) error {
+		if *protoPtr == "" || event.Protocol == *protoPtr {
+			builder.Add(event)
+		}
+		return nil
+	}
+	if *inputPtr != "" {
+		err = readEventLines(*inputPtr, from, to, add)
+	} else {
+		err = queryEventStore(*dbPtr, *configPtr, from, to, add)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	if err := report.Write(os.Stdout, builder.Report(), *formatPtr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func queryEventStore(dbPath, configFile string, from, to time.Time, fn func(certainly.Event) error) error {
+	if dbPath == "" {
+		config, _, err := certainly.ReadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		dbPath = config.Events.Path
+	}
+	store, err := events.OpenBoltStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Query(from, to, fn)
+}
+
+// readEventLines reads the events from a JSON lines file, skipping the events outside of the time range the same way
+// as the event store
+func readEventLines(fname string, from, to time.Time, fn func(certainly.Event) error) error {
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		f, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	scanner := bufio.NewScanner(r)
+	// The events have the raw HTTP requests and mails, so the lines can be long
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var event certainly.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s:%d: %w", fname, line, err)
+		}
+		if (!from.IsZero() && event.Time.Before(from)) || (!to.IsZero() && event.Time.After(to)) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func isFormat(format string) bool {
+	for _, f := range report.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
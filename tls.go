@@ -4,17 +4,21 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/happycakefriends/certainly/pkg/certainly"
+	"github.com/happycakefriends/certainly/pkg/rules"
 	"github.com/happycakefriends/certainly/pkg/util"
 	"go.uber.org/zap"
 )
 
-func setupTLS(dnsservers []certainly.CertainlyNS, config *certainly.CertainlyCFG, sugar *zap.SugaredLogger) (*tls.Config, error) {
-	provider := certainly.NewChallengeProvider(dnsservers)
+// setupTLS creates the TLS config for the servers. The certificate decisions are made with the current configuration
+// returned by currentConfig and the current filters, so that the changes to the filters and rewrites apply without a restart.
+func setupTLS(dnsserver certainly.CertainlyNS, currentConfig func() *certainly.CertainlyCFG, filters *tlsFilters, sugar *zap.SugaredLogger) (*tls.Config, error) {
+	config := currentConfig()
+	provider := certainly.NewChallengeProvider(dnsserver)
 	certmagic.Default.Logger = sugar.Desugar()
 	storage := certmagic.FileStorage{Path: config.General.ACMECacheDir}
 
@@ -27,15 +31,28 @@ func setupTLS(dnsservers []certainly.CertainlyNS, config *certainly.CertainlyCFG
 	certmagic.DefaultACME.Email = ""
 	certmagic.Default.OnDemand = new(certmagic.OnDemandConfig)
 	certmagic.Default.OnDemand.DecisionFunc = func(ctx context.Context, name string) error {
-		if ShouldFilterTLS(config, name) {
-			return fmt.Errorf("not allowed due to tls filter configuration")
+		config := currentConfig()
+		if filter := filters.Match(name); filter != nil {
+			return fmt.Errorf("not allowed due to tls filter %s", filter.Name)
 		}
-		for _, domain := range config.NS.Domains {
+		for _, domain := range managedDomains(dnsserver, config) {
 			if strings.HasSuffix(name, fmt.Sprintf(".%s", domain)) || name == domain {
-				if util.ShouldRewrite(name, config.Rewrites) {
-					if config.General.TLSUpstreamCheck && !util.ExistsUpstream(util.ReplaceApex(name, config.Rewrites)) {
+				if util.ShouldRewrite(name, config.Rewrites) && config.General.TLSUpstreamCheck {
+					upstreamName := util.ReplaceApex(name, config.Rewrites)
+					resolution, err := util.Resolve(upstreamName)
+					if !resolution.Exists {
+						sugar.Infow("Upstream check failed for domain",
+							"domain", name,
+							"upstream", upstreamName,
+							"path", resolution.Path,
+							"error", err)
 						return fmt.Errorf("no valid upstream record found for domain %s", name)
 					}
+					sugar.Debugw("Upstream check passed for domain",
+						"domain", name,
+						"upstream", upstreamName,
+						"path", resolution.Path,
+						"addrs", resolution.Addrs)
 				}
 				return nil
 			}
@@ -49,7 +66,7 @@ func setupTLS(dnsservers []certainly.CertainlyNS, config *certainly.CertainlyCFG
 	magicConf.DefaultServerName = config.NS.DefaultDomain
 	// Make sure we're requesting wildcard certificates for all subdomains
 	magicConf.SubjectTransformer = func(ctx context.Context, name string) string {
-		if certainly.IsManagedApex(name, config.NS.Domains) {
+		if certainly.IsManagedApex(name, managedDomains(dnsserver, currentConfig())) {
 			return name
 		}
 		return certainly.TransformToWildcard(name)
@@ -73,11 +90,45 @@ func setupTLS(dnsservers []certainly.CertainlyNS, config *certainly.CertainlyCFG
 	return magictls, err
 }
 
-func ShouldFilterTLS(config *certainly.CertainlyCFG, domain string) bool {
-	for _, filter := range config.General.TLSFilters {
-		if match, _ := regexp.MatchString(filter, domain); match {
-			return true
+// tlsFilters holds the compiled TLS filters, which are recompiled when the configuration is reloaded
+type tlsFilters struct {
+	mu    sync.RWMutex
+	rules *rules.Set
+}
+
+func newTLSFilters(config *certainly.CertainlyCFG) (*tlsFilters, error) {
+	f := &tlsFilters{}
+	return f, f.Reload(config)
+}
+
+func (f *tlsFilters) Reload(config *certainly.CertainlyCFG) error {
+	set, err := rules.Compile(rules.FromPatterns("general.tls_filters", config.General.TLSFilters))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = set
+	return nil
+}
+
+// Match returns the filter matching the domain, or nil if certificates can be issued for it
+func (f *tlsFilters) Match(domain string) *rules.Rule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules.Match(domain)
+}
+
+// managedDomains returns the apex domains to issue certificates for, including the ones added at runtime.
+// The default domain is only included if it's explicitly listed in the configuration.
+func managedDomains(dnsserver certainly.CertainlyNS, config *certainly.CertainlyCFG) []string {
+	domains := []string{}
+	for _, d := range dnsserver.ListDomains() {
+		d = strings.TrimSuffix(d, ".")
+		if strings.EqualFold(d, strings.TrimSuffix(config.NS.DefaultDomain, ".")) && !certainly.IsManagedApex(d, config.NS.Domains) {
+			continue
 		}
+		domains = append(domains, d)
 	}
-	return false
+	return domains
 }